@@ -0,0 +1,164 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// supportsIPv4Map reports whether the host can open dual-stack IPv6 sockets that
+// also accept IPv4 traffic through IPv4-mapped IPv6 addresses
+var supportsIPv4Map = sync.OnceValue(func() bool {
+	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return false
+	}
+	defer syscall.Close(fd)
+
+	return syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, 0) == nil
+})
+
+// ipFamily picks the socket family for network and ip the same way the net package does,
+// wildcard listeners on "tcp" or "udp" become dual-stack IPv6 sockets whenever the host supports it
+func ipFamily(network string, ip net.IP, listen bool) (family int, ipv6only bool) {
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return syscall.AF_INET, false
+	case strings.HasSuffix(network, "6"):
+		return syscall.AF_INET6, true
+	}
+
+	if listen && (len(ip) == 0 || ip.IsUnspecified()) && supportsIPv4Map() {
+		return syscall.AF_INET6, false
+	}
+
+	if len(ip) == 0 || ip.To4() != nil {
+		return syscall.AF_INET, false
+	}
+
+	return syscall.AF_INET6, false
+}
+
+// openIPSocket opens a blocking socket of the given family and type, configuring IPV6_V6ONLY for IPv6 sockets
+func openIPSocket(family int, sotype int, ipv6only bool) (int, error) {
+	fd, err := syscall.Socket(family, sotype|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+
+	if family == syscall.AF_INET6 {
+		v6only := 0
+		if ipv6only {
+			v6only = 1
+		}
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY, v6only)
+		if err != nil {
+			_ = syscall.Close(fd)
+			return -1, err
+		}
+	}
+
+	return fd, nil
+}
+
+func ipToSockaddr(family int, ip net.IP, port int, zone string) (syscall.Sockaddr, error) {
+	switch family {
+	case syscall.AF_INET:
+		if len(ip) == 0 {
+			ip = net.IPv4zero
+		}
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return nil, &net.AddrError{Err: "non-IPv4 address", Addr: ip.String()}
+		}
+		return &syscall.SockaddrInet4{Port: port, Addr: [4]byte(ip4)}, nil
+	case syscall.AF_INET6:
+		if len(ip) == 0 || ip.Equal(net.IPv4zero) {
+			ip = net.IPv6zero
+		}
+		ip6 := ip.To16()
+		if ip6 == nil {
+			return nil, &net.AddrError{Err: "non-IPv6 address", Addr: ip.String()}
+		}
+		return &syscall.SockaddrInet6{Port: port, Addr: [16]byte(ip6), ZoneId: zoneToIndex(zone)}, nil
+	}
+	return nil, &net.AddrError{Err: "unexpected socket family", Addr: ip.String()}
+}
+
+func sockaddrToAddr(network string, sa syscall.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		return ipAddr(network, append(net.IP(nil), sa.Addr[:]...), sa.Port, "")
+	case *syscall.SockaddrInet6:
+		return ipAddr(network, append(net.IP(nil), sa.Addr[:]...), sa.Port, indexToZone(sa.ZoneId))
+	case *syscall.SockaddrUnix:
+		return &net.UnixAddr{Name: sa.Name, Net: network}
+	}
+	return nil
+}
+
+// ipAddr returns a *net.UDPAddr for datagram networks and a *net.TCPAddr otherwise
+func ipAddr(network string, ip net.IP, port int, zone string) net.Addr {
+	if isDatagram(network) {
+		return &net.UDPAddr{IP: ip, Port: port, Zone: zone}
+	}
+	return &net.TCPAddr{IP: ip, Port: port, Zone: zone}
+}
+
+// isConnectionOriented reports whether network uses stream or seqpacket sockets, on which a
+// zero length read means that the peer has shut down the connection
+func isConnectionOriented(network string) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix", "unixpacket":
+		return true
+	}
+	return false
+}
+
+// isDatagram reports whether network uses datagram sockets
+func isDatagram(network string) bool {
+	switch network {
+	case "udp", "udp4", "udp6":
+		return true
+	}
+	return false
+}
+
+func zoneToIndex(zone string) uint32 {
+	if zone == "" {
+		return 0
+	}
+	if iface, err := net.InterfaceByName(zone); err == nil {
+		return uint32(iface.Index)
+	}
+	index, _ := strconv.ParseUint(zone, 10, 32)
+	return uint32(index)
+}
+
+func indexToZone(index uint32) string {
+	if index == 0 {
+		return ""
+	}
+	if iface, err := net.InterfaceByIndex(int(index)); err == nil {
+		return iface.Name
+	}
+	return strconv.FormatUint(uint64(index), 10)
+}
@@ -0,0 +1,105 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"fmt"
+)
+
+// Reason explains why io_uring is or is not available
+type Reason uint8
+
+const (
+	// ReasonAvailable means a ring could be set up
+	ReasonAvailable Reason = iota
+
+	// ReasonUnsupportedPlatform means the package was built for an operating system other than Linux
+	ReasonUnsupportedPlatform
+
+	// ReasonUnsupportedKernel means the kernel predates io_uring (5.1) or was built without it
+	ReasonUnsupportedKernel
+
+	// ReasonDisabledBySysctl means io_uring is disabled for everyone through kernel.io_uring_disabled
+	ReasonDisabledBySysctl
+
+	// ReasonRestrictedToGroup means kernel.io_uring_disabled restricts io_uring to privileged
+	// processes and members of the kernel.io_uring_group group
+	ReasonRestrictedToGroup
+
+	// ReasonDeniedBySeccomp means a seccomp filter, usually installed by a container
+	// runtime, blocks the io_uring syscalls
+	ReasonDeniedBySeccomp
+
+	// ReasonDenied means setting up a ring was denied for another reason, such as an LSM policy
+	ReasonDenied
+
+	// ReasonSetupFailed means setting up a ring failed with an error that does not indicate
+	// io_uring is unavailable, such as running out of memory or file descriptors
+	ReasonSetupFailed
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonAvailable:
+		return "available"
+	case ReasonUnsupportedPlatform:
+		return "unsupported platform"
+	case ReasonUnsupportedKernel:
+		return "unsupported kernel"
+	case ReasonDisabledBySysctl:
+		return "disabled by sysctl"
+	case ReasonRestrictedToGroup:
+		return "restricted to group"
+	case ReasonDeniedBySeccomp:
+		return "denied by seccomp"
+	case ReasonDenied:
+		return "denied"
+	case ReasonSetupFailed:
+		return "setup failed"
+	}
+	return fmt.Sprintf("Reason(%d)", uint8(r))
+}
+
+// AvailabilityReport is the result of checking whether io_uring can be used
+type AvailabilityReport struct {
+	// Available is true if a ring could be set up
+	Available bool
+
+	// Reason explains why io_uring is not available
+	Reason Reason
+
+	// Err is the error returned while setting up a ring
+	Err error
+
+	// Kernel is the release of the running kernel
+	Kernel string
+
+	// Group is the value of kernel.io_uring_group when the reason is ReasonRestrictedToGroup
+	Group int
+}
+
+func (a AvailabilityReport) String() string {
+	switch {
+	case a.Available:
+		return "io_uring is available"
+	case a.Reason == ReasonRestrictedToGroup:
+		return fmt.Sprintf("io_uring is not available: %s %d (%v)", a.Reason, a.Group, a.Err)
+	case a.Err != nil:
+		return fmt.Sprintf("io_uring is not available: %s (%v)", a.Reason, a.Err)
+	}
+	return fmt.Sprintf("io_uring is not available: %s", a.Reason)
+}
@@ -0,0 +1,77 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestAvailability(t *testing.T) {
+	report := Availability()
+	require.True(t, report.Available, report.String())
+	require.Equal(t, ReasonAvailable, report.Reason)
+	require.NoError(t, report.Err)
+	require.NotEmpty(t, report.Kernel)
+	require.True(t, IsAvailable())
+}
+
+func TestAvailabilityReasons(t *testing.T) {
+	dir := t.TempDir()
+	kernel := filepath.Join(dir, "kernel")
+	require.NoError(t, os.Mkdir(kernel, 0755))
+	status := filepath.Join(dir, "status")
+
+	oldProcSysKernel, oldProcSelfStatus := procSysKernel, procSelfStatus
+	procSysKernel, procSelfStatus = kernel, status
+	t.Cleanup(func() {
+		procSysKernel, procSelfStatus = oldProcSysKernel, oldProcSelfStatus
+	})
+
+	write := func(disabled string, group string, seccomp string) {
+		require.NoError(t, os.WriteFile(filepath.Join(kernel, "io_uring_disabled"), []byte(disabled+"\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(kernel, "io_uring_group"), []byte(group+"\n"), 0644))
+		require.NoError(t, os.WriteFile(status, []byte("Name:\ttest\nSeccomp:\t"+seccomp+"\nSeccomp_filters:\t1\n"), 0644))
+	}
+
+	write("2", "-1", "0")
+	require.Equal(t, ReasonDisabledBySysctl, checkAvailability(syscall.EPERM).Reason)
+
+	write("1", "1000", "0")
+	report := checkAvailability(syscall.EPERM)
+	require.Equal(t, ReasonRestrictedToGroup, report.Reason)
+	require.Equal(t, 1000, report.Group)
+	require.ErrorIs(t, report.Err, syscall.EPERM)
+
+	write("0", "-1", "2")
+	require.Equal(t, ReasonDeniedBySeccomp, checkAvailability(syscall.EPERM).Reason)
+	require.Equal(t, ReasonDeniedBySeccomp, checkAvailability(syscall.ENOSYS).Reason)
+
+	write("0", "-1", "0")
+	require.Equal(t, ReasonDenied, checkAvailability(syscall.EPERM).Reason)
+	require.Equal(t, ReasonUnsupportedKernel, checkAvailability(syscall.ENOSYS).Reason)
+	require.Equal(t, ReasonSetupFailed, checkAvailability(syscall.ENOMEM).Reason)
+	require.False(t, checkAvailability(syscall.ENOMEM).Available)
+
+	require.True(t, kernelAtLeast("5.1.0", 5, 1))
+	require.True(t, kernelAtLeast("6.18.44-generic", 5, 1))
+	require.False(t, kernelAtLeast("4.19.112", 5, 1))
+	require.False(t, kernelAtLeast("unknown", 5, 1))
+}
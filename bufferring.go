@@ -0,0 +1,266 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"errors"
+	"fmt"
+	"github.com/loopholelabs/iouring/pkg/linked"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// MaxBufferRingEntries is the largest number of entries a BufferRing can have
+	MaxBufferRingEntries = 32768
+)
+
+var (
+	ErrBufferGroupFull   = errors.New("buffer group is full")
+	ErrBufferGroupClosed = errors.New("buffer group is closed")
+	ErrInvalidBufferID   = errors.New("invalid buffer ID")
+	ErrInvalidBufferRing = errors.New("buffer ring entries must be a power of 2 no larger than 32768")
+)
+
+// BufferGroup is a group of provided buffers that requests with SQEntry.SetBufferSelect pick
+// a buffer from when they are ready to transfer data, instead of holding on to a buffer of their
+// own while they wait
+//
+// The ID of the buffer a request picked is returned by CQEvent.BufferID and Completion.BufferID,
+// and the buffer belongs to the caller until it is handed back to the group with Recycle.
+// It is implemented by BufferRing and ProvidedBuffers, and NewBufferGroup picks the one
+// the kernel supports.
+type BufferGroup interface {
+	// ID returns the buffer group ID requests select the group with
+	ID() uint16
+
+	// Add hands buf to the group and returns the ID it was given
+	Add(buf []byte) (uint16, error)
+
+	// Buffer returns the buffer with the given ID, or nil if there is none
+	Buffer(id uint16) []byte
+
+	// Recycle hands the buffer with the given ID back to the group once the data
+	// a request read into it has been consumed
+	Recycle(id uint16) error
+
+	// Close removes the group from the ring, requests that select a buffer from
+	// it afterwards fail with ENOBUFS
+	Close() error
+}
+
+var _ BufferGroup = (*BufferRing)(nil)
+
+// BufferID returns the ID of the buffer stored in the CQE flags f, the second result
+// is false if CQEventFlagBuffer is not set
+func (f CQEventFlag) BufferID() (uint16, bool) {
+	if f&CQEventFlagBuffer == 0 {
+		return 0, false
+	}
+	return uint16(f >> CQEventBufferShift), true
+}
+
+// BufferRing is a BufferGroup backed by a ring of buffer entries that is shared with the
+// kernel, which makes handing buffers to the kernel as cheap as storing to memory
+//
+// The ring memory is either allocated by the BufferRing, or allocated by the kernel and
+// mapped from the ring fd, which is required on kernels that restrict where the ring may live.
+// BufferRing requires IORING_REGISTER_PBUF_RING (Linux 5.19), and kernel allocated rings
+// require IOU_PBUF_RING_MMAP (Linux 6.4).
+type BufferRing struct {
+	ring *Ring
+	id   uint16
+
+	mu      sync.Mutex
+	entries []BufRingEntry
+	mask    uint16
+	tail    uint16
+	buffers [][]byte
+	closed  bool
+}
+
+// RegisterBufferRing registers a buffer ring with the given number of entries as buffer group id,
+// with ring memory that is allocated in user space
+func (r *Ring) RegisterBufferRing(id uint16, entries uint32) (*BufferRing, error) {
+	return r.registerBufferRing(id, entries, false)
+}
+
+// RegisterMappedBufferRing is like RegisterBufferRing, but has the kernel allocate the
+// ring memory, which is then mapped into user space
+func (r *Ring) RegisterMappedBufferRing(id uint16, entries uint32) (*BufferRing, error) {
+	return r.registerBufferRing(id, entries, true)
+}
+
+func (r *Ring) registerBufferRing(id uint16, entries uint32, mapped bool) (*BufferRing, error) {
+	if entries == 0 || entries > MaxBufferRingEntries || entries&(entries-1) != 0 {
+		return nil, fmt.Errorf("error while registering buffer ring %d with %d entries: %w", id, entries, ErrInvalidBufferRing)
+	}
+
+	size := uintptr(entries) * unsafe.Sizeof(BufRingEntry{})
+	reg := BufReg{
+		RingEntries: entries,
+		BufferGroup: id,
+	}
+
+	var memory unsafe.Pointer
+	var err error
+	if mapped {
+		reg.Flags = uint16(PBufRingFlagMMap)
+	} else {
+		pageSize := uintptr(os.Getpagesize())
+		size = (size + pageSize - 1) &^ (pageSize - 1)
+		memory, err = mmap(size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANONYMOUS|syscall.MAP_PRIVATE, -1, 0)
+		if err != nil {
+			return nil, fmt.Errorf("error while allocating memory for buffer ring %d: %w", id, err)
+		}
+		reg.RingAddress = uint64(uintptr(memory))
+	}
+
+	_, err = r.RegisterBufRing(&reg)
+	if err != nil {
+		if memory != nil {
+			_ = linked.MUnmap(uintptr(memory), size)
+		}
+		return nil, fmt.Errorf("error while registering buffer ring %d with %d entries on ring with fd %d: %w", id, entries, r.FD, err)
+	}
+
+	if mapped {
+		offset := PBUFRingOffset | uint64(id)<<PBUFShiftOffset
+		memory, err = mmap(size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE, r.FD, int64(offset))
+		if err != nil {
+			_, _ = r.UnregisterBufRing(id)
+			return nil, fmt.Errorf("error while mapping buffer ring %d of ring with fd %d: %w", id, r.FD, err)
+		}
+	}
+
+	return &BufferRing{
+		ring:    r,
+		id:      id,
+		entries: unsafe.Slice((*BufRingEntry)(memory), entries),
+		mask:    uint16(entries - 1),
+	}, nil
+}
+
+// ID returns the buffer group ID of the ring
+func (b *BufferRing) ID() uint16 {
+	return b.id
+}
+
+// Add hands buf to the kernel and returns the buffer ID it was given, at most as
+// many buffers as the ring has entries can be added
+func (b *BufferRing) Add(buf []byte) (uint16, error) {
+	if len(buf) == 0 {
+		return 0, ErrInvalidBufferID
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, ErrBufferGroupClosed
+	}
+	if len(b.buffers) == len(b.entries) {
+		return 0, ErrBufferGroupFull
+	}
+
+	id := uint16(len(b.buffers))
+	b.buffers = append(b.buffers, buf)
+	b.push(id)
+	b.publish()
+
+	return id, nil
+}
+
+// Buffer returns the buffer with the given ID, or nil if there is none
+func (b *BufferRing) Buffer(id uint16) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if int(id) >= len(b.buffers) {
+		return nil
+	}
+	return b.buffers[id]
+}
+
+// Recycle hands the buffer with the given ID back to the kernel
+func (b *BufferRing) Recycle(id uint16) error {
+	return b.RecycleBatch(id)
+}
+
+// RecycleBatch hands the buffers with the given IDs back to the kernel at once
+func (b *BufferRing) RecycleBatch(ids ...uint16) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBufferGroupClosed
+	}
+	for _, id := range ids {
+		if int(id) >= len(b.buffers) {
+			return ErrInvalidBufferID
+		}
+	}
+
+	for _, id := range ids {
+		b.push(id)
+	}
+	b.publish()
+
+	return nil
+}
+
+// push stores the buffer with the given ID in the entry at the tail like io_uring_buf_ring_add,
+// the caller must hold mu
+func (b *BufferRing) push(id uint16) {
+	buf := b.buffers[id]
+	entry := &b.entries[b.tail&b.mask]
+	entry.Address = uint64(uintptr(unsafe.Pointer(&buf[0])))
+	entry.Length = uint32(len(buf))
+	entry.BufferID = id
+	b.tail++
+}
+
+// publish makes the entries that were pushed visible to the kernel like io_uring_buf_ring_advance,
+// the caller must hold mu
+func (b *BufferRing) publish() {
+	// The tail shares a 4 byte word with the buffer ID of the first entry, so both are
+	// stored together to publish the tail with a single atomic store
+	pair := [2]uint16{b.entries[0].BufferID, b.tail}
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&b.entries[0].BufferID)), *(*uint32)(unsafe.Pointer(&pair)))
+}
+
+// Close unregisters the buffer ring and frees its memory, the buffers that were added
+// to it belong to the caller again
+func (b *BufferRing) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBufferGroupClosed
+	}
+	b.closed = true
+
+	_, err := b.ring.UnregisterBufRing(b.id)
+	size := uintptr(len(b.entries)) * unsafe.Sizeof(BufRingEntry{})
+	pageSize := uintptr(os.Getpagesize())
+	_ = linked.MUnmap(uintptr(unsafe.Pointer(&b.entries[0])), (size+pageSize-1)&^(pageSize-1))
+	b.entries = nil
+	if err != nil {
+		return fmt.Errorf("error while unregistering buffer ring %d on ring with fd %d: %w", b.id, b.ring.FD, err)
+	}
+
+	return nil
+}
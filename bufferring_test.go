@@ -0,0 +1,109 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"github.com/stretchr/testify/require"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBufferRing(t *testing.T) {
+	register := map[string]func(ring *Ring, id uint16, entries uint32) (*BufferRing, error){
+		"user":   (*Ring).RegisterBufferRing,
+		"mapped": (*Ring).RegisterMappedBufferRing,
+	}
+
+	for name, register := range register {
+		t.Run(name, func(t *testing.T) {
+			ring := newTestRing(t, 8)
+
+			_, err := register(ring, 1, 3)
+			require.ErrorIs(t, err, ErrInvalidBufferRing)
+
+			group, err := register(ring, 1, 2)
+			require.NoError(t, err)
+			require.Equal(t, uint16(1), group.ID())
+
+			id, err := group.Add(make([]byte, 16))
+			require.NoError(t, err)
+			require.Zero(t, id)
+
+			var fds [2]int
+			require.NoError(t, syscall.Pipe(fds[:]))
+			t.Cleanup(func() {
+				_ = syscall.Close(fds[0])
+				_ = syscall.Close(fds[1])
+			})
+
+			read := func(data string) *CQEvent {
+				_, err := syscall.Write(fds[1], []byte(data))
+				require.NoError(t, err)
+
+				sqe := ring.GetSQEntry()
+				require.NotNil(t, sqe)
+				sqe.PrepareRW(OpCodeRead, fds[0], 0, 16, 0)
+				sqe.SetBufferSelect(group.ID())
+				_, err = ring.Submit()
+				require.NoError(t, err)
+
+				cqe, err := ring.WaitCQEventTimeout(time.Second)
+				require.NoError(t, err)
+				ring.CQESeen(cqe)
+				return cqe
+			}
+
+			cqe := read("hello")
+			require.Equal(t, int32(5), cqe.Res)
+			id, ok := cqe.BufferID()
+			require.True(t, ok)
+			require.Zero(t, id)
+			require.Equal(t, "hello", string(group.Buffer(id)[:cqe.Res]))
+
+			// The only buffer is still held by the caller
+			cqe = read("world")
+			require.Equal(t, -int32(syscall.ENOBUFS), cqe.Res)
+			_, ok = cqe.BufferID()
+			require.False(t, ok)
+
+			require.NoError(t, group.Recycle(id))
+			cqe = read("")
+			require.Equal(t, int32(5), cqe.Res)
+			id, ok = cqe.BufferID()
+			require.True(t, ok)
+			require.Equal(t, "world", string(group.Buffer(id)[:cqe.Res]))
+
+			id, err = group.Add(make([]byte, 16))
+			require.NoError(t, err)
+			require.Equal(t, uint16(1), id)
+			_, err = group.Add(make([]byte, 16))
+			require.ErrorIs(t, err, ErrBufferGroupFull)
+			require.ErrorIs(t, group.Recycle(2), ErrInvalidBufferID)
+
+			cqe = read("again")
+			id, ok = cqe.BufferID()
+			require.True(t, ok)
+			require.Equal(t, uint16(1), id)
+			require.Equal(t, "again", string(group.Buffer(id)[:cqe.Res]))
+
+			require.NoError(t, group.Close())
+			require.ErrorIs(t, group.Close(), ErrBufferGroupClosed)
+			require.ErrorIs(t, group.Recycle(0), ErrBufferGroupClosed)
+		})
+	}
+}
@@ -0,0 +1,258 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	ErrBufferTableFull   = errors.New("buffer table is full")
+	ErrBufferTableClosed = errors.New("buffer table is unregistered")
+	ErrInvalidBufferSlot = errors.New("invalid buffer table slot")
+)
+
+// BufferTable manages the registered (fixed) buffers of a Ring
+//
+// Read and write fixed requests refer to a slot of the table by its index, and the kernel
+// pins the pages of every registered buffer once instead of on every request. The table is
+// registered sparse, so every slot starts out empty.
+//
+// Slots can be given a non-zero tag, and once a tagged buffer has been replaced or freed,
+// and the kernel no longer uses it, a CQE is posted with the tag and TagFlag as its UserData
+// and no other payload. The table keeps tagged buffers alive until Release is called with
+// their tag, which a Dispatcher does on its own once the table is routed to it with
+// RouteBufferTags, so buffers can be rotated without unregistering the table. Tags must be
+// unique and must not overlap TagFlag or FileTagFlag.
+type BufferTable struct {
+	ring *Ring
+
+	mu      sync.Mutex
+	buffers [][]byte
+	tags    []uint64
+	retired map[uint64][]byte
+	next    uint32
+	closed  bool
+}
+
+// RegisterBufferTable registers a sparse buffer table with size slots on the ring, a ring
+// can only have one buffer table registered at a time
+//
+// Kernels without IORING_RSRC_REGISTER_SPARSE (before 5.19) fall back to registering size
+// empty buffers, which requires IORING_REGISTER_BUFFERS2 (Linux 5.13).
+func (r *Ring) RegisterBufferTable(size uint32) (*BufferTable, error) {
+	if size == 0 {
+		return nil, fmt.Errorf("error while registering buffer table on ring with fd %d: %w", r.FD, ErrInvalidBufferSlot)
+	}
+
+	_, err := r.RegisterBuffersSparse(size)
+	if errors.Is(err, syscall.EINVAL) {
+		_, err = r.RegisterBuffersTags(make([]syscall.Iovec, size), make([]uint64, size))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while registering buffer table with %d slots on ring with fd %d: %w", size, r.FD, err)
+	}
+
+	return &BufferTable{
+		ring:    r,
+		buffers: make([][]byte, size),
+		tags:    make([]uint64, size),
+		retired: make(map[uint64][]byte),
+	}, nil
+}
+
+// Size returns the number of slots in the table
+func (t *BufferTable) Size() uint32 {
+	return uint32(len(t.buffers))
+}
+
+// Buffer returns the buffer registered in slot, or nil if the slot is empty
+func (t *BufferTable) Buffer(slot uint32) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if slot >= uint32(len(t.buffers)) {
+		return nil
+	}
+	return t.buffers[slot]
+}
+
+// Register installs buf into the first free slot of the table and returns the slot
+func (t *BufferTable) Register(buf []byte, tag uint64) (uint32, error) {
+	if len(buf) == 0 {
+		return 0, ErrInvalidBufferSlot
+	}
+	if tag&(TagFlag|FileTagFlag) != 0 {
+		return 0, ErrInvalidTag
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return 0, ErrBufferTableClosed
+	}
+
+	size := uint32(len(t.buffers))
+	for i := uint32(0); i < size; i++ {
+		slot := (t.next + i) % size
+		if t.buffers[slot] != nil {
+			continue
+		}
+
+		err := t.update(slot, [][]byte{buf}, []uint64{tag})
+		if err != nil {
+			return 0, err
+		}
+		t.next = slot + 1
+
+		return slot, nil
+	}
+
+	return 0, ErrBufferTableFull
+}
+
+// Set installs buf into slot, replacing the buffer that was in it
+func (t *BufferTable) Set(slot uint32, buf []byte, tag uint64) error {
+	return t.Update(slot, [][]byte{buf}, []uint64{tag})
+}
+
+// Free empties slot, the kernel drops its reference to the buffer once the requests
+// that are using it have completed
+func (t *BufferTable) Free(slot uint32) error {
+	return t.Update(slot, [][]byte{nil}, nil)
+}
+
+// Update replaces the slots starting at offset with bufs, where an empty buffer empties a slot,
+// tags is either nil or holds a tag for every buffer, and empty slots cannot be tagged
+//
+// Untagged buffers that are replaced are no longer referenced by the table, so the caller has
+// to keep them alive until the requests that use them have completed.
+func (t *BufferTable) Update(offset uint32, bufs [][]byte, tags []uint64) error {
+	if len(bufs) == 0 {
+		return nil
+	}
+	if tags != nil && len(tags) != len(bufs) {
+		return fmt.Errorf("error while updating buffer table: got %d tags for %d buffers", len(tags), len(bufs))
+	}
+	if tags == nil {
+		tags = make([]uint64, len(bufs))
+	}
+	for _, tag := range tags {
+		if tag&(TagFlag|FileTagFlag) != 0 {
+			return ErrInvalidTag
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrBufferTableClosed
+	}
+	if uint64(offset)+uint64(len(bufs)) > uint64(len(t.buffers)) {
+		return ErrInvalidBufferSlot
+	}
+
+	return t.update(offset, bufs, tags)
+}
+
+// update replaces the slots starting at offset with bufs, the caller must hold mu
+func (t *BufferTable) update(offset uint32, bufs [][]byte, tags []uint64) error {
+	iovecs := make([]syscall.Iovec, len(bufs))
+	for i, buf := range bufs {
+		if len(buf) == 0 {
+			continue
+		}
+		iovecs[i].Base = (*byte)(unsafe.Pointer(&buf[0]))
+		iovecs[i].SetLen(len(buf))
+	}
+
+	// The kernel posts the tags as they are registered, so they are marked with TagFlag up front
+	kernelTags := make([]uint64, len(tags))
+	for i, tag := range tags {
+		if tag != 0 {
+			kernelTags[i] = tag | TagFlag
+		}
+	}
+
+	n, err := t.ring.RegisterBuffersUpdateTag(offset, iovecs, kernelTags)
+	if err == nil && n != uint(len(bufs)) {
+		err = syscall.EFAULT
+	}
+	if err != nil {
+		return fmt.Errorf("error while updating %d buffer table slots at offset %d on ring with fd %d: %w", len(bufs), offset, t.ring.FD, err)
+	}
+
+	for i, buf := range bufs {
+		slot := offset + uint32(i)
+		t.retire(slot)
+		if len(buf) > 0 {
+			t.buffers[slot], t.tags[slot] = buf, tags[i]
+		}
+	}
+
+	return nil
+}
+
+// retire empties slot, keeping its buffer alive until its tag CQE has been released if it
+// has a tag, the caller must hold mu
+func (t *BufferTable) retire(slot uint32) {
+	if t.buffers[slot] != nil && t.tags[slot] != 0 {
+		t.retired[t.tags[slot]] = t.buffers[slot]
+	}
+	t.buffers[slot], t.tags[slot] = nil, 0
+}
+
+// Release is called with the UserData of a tag CQE, or with the tag itself, and returns the buffer
+// that was replaced or freed, which the kernel no longer uses and which can be reused from now on
+func (t *BufferTable) Release(tag uint64) ([]byte, bool) {
+	tag &^= TagFlag
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	buf, ok := t.retired[tag]
+	if ok {
+		delete(t.retired, tag)
+	}
+
+	return buf, ok
+}
+
+// Unregister unregisters the table, which posts the tag CQEs of every tagged buffer
+// that is still registered
+func (t *BufferTable) Unregister() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrBufferTableClosed
+	}
+
+	_, err := t.ring.UnregisterBuffers()
+	if err != nil {
+		return fmt.Errorf("error while unregistering buffer table on ring with fd %d: %w", t.ring.FD, err)
+	}
+	t.closed = true
+
+	for slot := range t.buffers {
+		t.retire(uint32(slot))
+	}
+
+	return nil
+}
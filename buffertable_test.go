@@ -0,0 +1,102 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"github.com/stretchr/testify/require"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestBufferTable(t *testing.T) {
+	ring := newTestRing(t, 8)
+
+	table, err := ring.RegisterBufferTable(2)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), table.Size())
+
+	var fds [2]int
+	require.NoError(t, syscall.Pipe(fds[:]))
+	t.Cleanup(func() {
+		_ = syscall.Close(fds[0])
+		_ = syscall.Close(fds[1])
+	})
+
+	first := make([]byte, 64)
+	slot, err := table.Register(first, 1)
+	require.NoError(t, err)
+	require.Zero(t, slot)
+	require.Equal(t, first, table.Buffer(slot))
+
+	_, err = syscall.Write(fds[1], []byte("hello"))
+	require.NoError(t, err)
+
+	sqe := ring.GetSQEntry()
+	require.NotNil(t, sqe)
+	sqe.PrepareRW(OpCodeReadFixed, fds[0], uintptr(unsafe.Pointer(&first[0])), uint32(len(first)), 0)
+	sqe.UnionBufferIndexPacked = uint16(slot)
+	sqe.UserData = 100
+	_, err = ring.Submit()
+	require.NoError(t, err)
+
+	cqe, err := ring.WaitCQEventTimeout(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, uint64(100), cqe.UserData)
+	require.Equal(t, int32(5), cqe.Res)
+	require.Equal(t, "hello", string(first[:5]))
+	ring.CQESeen(cqe)
+
+	// Replacing a tagged buffer posts its tag once the kernel has dropped it
+	second := make([]byte, 64)
+	require.NoError(t, table.Set(slot, second, 2))
+	cqe, err = ring.WaitCQEventTimeout(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 1|TagFlag, cqe.UserData)
+	ring.CQESeen(cqe)
+
+	released, ok := table.Release(1)
+	require.True(t, ok)
+	require.Equal(t, &first[0], &released[0])
+	_, ok = table.Release(1)
+	require.False(t, ok)
+
+	slot, err = table.Register(first, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), slot)
+	_, err = table.Register(first, 0)
+	require.ErrorIs(t, err, ErrBufferTableFull)
+	require.ErrorIs(t, table.Set(2, first, 0), ErrInvalidBufferSlot)
+	require.ErrorIs(t, table.Set(1, first, TagFlag), ErrInvalidTag)
+
+	require.NoError(t, table.Free(slot))
+	require.Nil(t, table.Buffer(slot))
+
+	require.NoError(t, table.Unregister())
+	cqe, err = ring.WaitCQEventTimeout(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 2|TagFlag, cqe.UserData)
+	ring.CQESeen(cqe)
+	released, ok = table.Release(2 | TagFlag)
+	require.True(t, ok)
+	require.Equal(t, &second[0], &released[0])
+
+	require.ErrorIs(t, table.Unregister(), ErrBufferTableClosed)
+	_, err = table.Register(first, 0)
+	require.ErrorIs(t, err, ErrBufferTableClosed)
+}
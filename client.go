@@ -17,7 +17,11 @@
 package iouring
 
 import (
+	"fmt"
 	"golang.org/x/sys/unix"
+	"net"
+	"strconv"
+	"syscall"
 	"unsafe"
 )
 
@@ -43,3 +47,157 @@ func NewClientAddress() *ClientAddress {
 		AddressPointer: addressPointer,
 	}
 }
+
+// Reset restores the address length so the ClientAddress can be reused for another request
+func (c *ClientAddress) Reset() {
+	*c.Length = unix.SizeofSockaddrAny
+}
+
+// Addr decodes the raw socket address written by the kernel into the address type used by network
+func (c *ClientAddress) Addr(network string) net.Addr {
+	switch c.Address.Addr.Family {
+	case unix.AF_INET, unix.AF_INET6:
+		ip, port, zone := c.ip()
+		return ipAddr(network, ip, port, zone)
+	case unix.AF_UNIX:
+		return c.UnixAddr(network)
+	}
+	return nil
+}
+
+// TCPAddr decodes the raw socket address written by the kernel
+func (c *ClientAddress) TCPAddr() *net.TCPAddr {
+	ip, port, zone := c.ip()
+	if ip == nil {
+		return nil
+	}
+	return &net.TCPAddr{IP: ip, Port: port, Zone: zone}
+}
+
+// UDPAddr decodes the raw socket address written by the kernel
+func (c *ClientAddress) UDPAddr() *net.UDPAddr {
+	ip, port, zone := c.ip()
+	if ip == nil {
+		return nil
+	}
+	return &net.UDPAddr{IP: ip, Port: port, Zone: zone}
+}
+
+// SetTCPAddr encodes addr for the given socket family into the raw socket address
+// so it can be passed to the kernel
+func (c *ClientAddress) SetTCPAddr(family int, addr *net.TCPAddr) error {
+	return c.setIP(family, addr.IP, addr.Port, addr.Zone)
+}
+
+// SetUDPAddr encodes addr for the given socket family into the raw socket address
+// so it can be passed to the kernel
+func (c *ClientAddress) SetUDPAddr(family int, addr *net.UDPAddr) error {
+	return c.setIP(family, addr.IP, addr.Port, addr.Zone)
+}
+
+func (c *ClientAddress) ip() (net.IP, int, string) {
+	switch c.Address.Addr.Family {
+	case unix.AF_INET:
+		raw := (*unix.RawSockaddrInet4)(unsafe.Pointer(c.Address))
+		return append(net.IP(nil), raw.Addr[:]...), decodePort(raw.Port), ""
+	case unix.AF_INET6:
+		raw := (*unix.RawSockaddrInet6)(unsafe.Pointer(c.Address))
+		return append(net.IP(nil), raw.Addr[:]...), decodePort(raw.Port), indexToZone(raw.Scope_id)
+	}
+	return nil, 0, ""
+}
+
+func (c *ClientAddress) setIP(family int, ip net.IP, port int, zone string) error {
+	sa, err := ipToSockaddr(family, ip, port, zone)
+	if err != nil {
+		return fmt.Errorf("error while encoding address %s: %w", net.JoinHostPort(ip.String(), strconv.Itoa(port)), err)
+	}
+
+	*c.Address = unix.RawSockaddrAny{}
+	switch sa := sa.(type) {
+	case *syscall.SockaddrInet4:
+		raw := (*unix.RawSockaddrInet4)(unsafe.Pointer(c.Address))
+		raw.Family = unix.AF_INET
+		raw.Port = encodePort(sa.Port)
+		raw.Addr = sa.Addr
+		*c.Length = unix.SizeofSockaddrInet4
+	case *syscall.SockaddrInet6:
+		raw := (*unix.RawSockaddrInet6)(unsafe.Pointer(c.Address))
+		raw.Family = unix.AF_INET6
+		raw.Port = encodePort(sa.Port)
+		raw.Addr = sa.Addr
+		raw.Scope_id = sa.ZoneId
+		*c.Length = unix.SizeofSockaddrInet6
+	}
+
+	return nil
+}
+
+// UnixAddr decodes the raw Unix socket address written by the kernel, abstract
+// socket names are returned with a leading '@'
+func (c *ClientAddress) UnixAddr(network string) *net.UnixAddr {
+	if c.Address.Addr.Family != unix.AF_UNIX {
+		return nil
+	}
+
+	raw := (*unix.RawSockaddrUnix)(unsafe.Pointer(c.Address))
+	length := int(*c.Length) - int(unsafe.Offsetof(raw.Path))
+	if length < 0 {
+		length = 0
+	}
+	if length > len(raw.Path) {
+		length = len(raw.Path)
+	}
+
+	path := unsafe.Slice((*byte)(unsafe.Pointer(&raw.Path[0])), length)
+	if length > 0 && path[0] == 0 {
+		return &net.UnixAddr{Name: "@" + string(path[1:]), Net: network}
+	}
+
+	n := 0
+	for n < length && path[n] != 0 {
+		n++
+	}
+	return &net.UnixAddr{Name: string(path[:n]), Net: network}
+}
+
+// SetUnixAddr encodes addr into the raw socket address so it can be passed to the kernel,
+// names starting with '@' are encoded as abstract socket names
+func (c *ClientAddress) SetUnixAddr(addr *net.UnixAddr) error {
+	*c.Address = unix.RawSockaddrAny{}
+	raw := (*unix.RawSockaddrUnix)(unsafe.Pointer(c.Address))
+
+	name := addr.Name
+	if len(name) > len(raw.Path) || (len(name) == len(raw.Path) && name[0] != '@') {
+		return fmt.Errorf("error while encoding address %s: %w", addr, unix.EINVAL)
+	}
+
+	raw.Family = unix.AF_UNIX
+	path := unsafe.Slice((*byte)(unsafe.Pointer(&raw.Path[0])), len(raw.Path))
+	copy(path, name)
+
+	length := uint32(unsafe.Offsetof(raw.Path))
+	if len(name) > 0 {
+		length += uint32(len(name)) + 1
+		if name[0] == '@' {
+			path[0] = 0
+			length--
+		}
+	}
+	*c.Length = length
+
+	return nil
+}
+
+// decodePort converts a port from network byte order
+func decodePort(port uint16) int {
+	p := (*[2]byte)(unsafe.Pointer(&port))
+	return int(p[0])<<8 | int(p[1])
+}
+
+// encodePort converts a port to network byte order
+func encodePort(port int) (encoded uint16) {
+	p := (*[2]byte)(unsafe.Pointer(&encoded))
+	p[0], p[1] = byte(port>>8), byte(port)
+	return
+}
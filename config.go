@@ -0,0 +1,362 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// MaxEntries is the largest number of SQ entries the kernel accepts without SetupClamp
+	MaxEntries = 32768
+
+	// MaxCQEntries is the largest number of CQ entries the kernel accepts without SetupClamp
+	MaxCQEntries = 2 * MaxEntries
+)
+
+var (
+	ErrInvalidRingConfig = errors.New("invalid ring configuration")
+)
+
+// RingConfig describes the submission and completion queues of a Ring and the setup flags
+// it is created with, it is turned into Params and validated by QueueInitConfig
+type RingConfig struct {
+	// Entries is the number of SQ entries, the kernel rounds it up to a power of two
+	Entries uint32
+
+	// CQEntries is the number of CQ entries, it sets SetupCQSize when it is not zero
+	// and must be at least Entries, by default the CQ is twice the size of the SQ
+	CQEntries uint32
+
+	// Clamp sets SetupClamp, which clamps Entries and CQEntries to the largest
+	// sizes the kernel supports instead of failing
+	Clamp bool
+
+	// SQPoll sets SetupSQPoll, which starts a kernel thread that polls the SQ
+	SQPoll bool
+
+	// SQThreadIdle is how long the SQPOLL thread polls an empty SQ before going to sleep,
+	// it is rounded to milliseconds and the kernel defaults to one second when it is zero
+	SQThreadIdle time.Duration
+
+	// SQThreadAffinity sets SetupSQAff, which pins the SQPOLL thread to SQThreadCPU
+	SQThreadAffinity bool
+	SQThreadCPU      uint32
+
+	// SingleIssuer sets SetupSingleIssuer, promising the kernel that only a single
+	// task submits requests to the ring, which must be the thread that created it
+	//
+	// Such rings cannot be used with a Submitter or Dispatcher, whose goroutines are not
+	// bound to that thread, so they must be driven from a goroutine that has called
+	// runtime.LockOSThread before creating the ring.
+	SingleIssuer bool
+
+	// DeferTaskRun sets SetupDeferTaskRun, which defers completion work until the ring
+	// is entered to wait for completions, it requires SingleIssuer
+	DeferTaskRun bool
+
+	// SubmitAll sets SetupSubmitAll, which keeps submitting the rest of a batch
+	// after a request fails to be submitted
+	SubmitAll bool
+
+	// CoopTaskRun sets SetupCoopTaskRun, which stops the kernel from interrupting
+	// the submitting task to run completion work
+	CoopTaskRun bool
+
+	// TaskRunFlag sets SetupTaskRunFlag, which raises SQStatusTaskRun when completion
+	// work is pending, it requires CoopTaskRun or DeferTaskRun
+	TaskRunFlag bool
+
+	// SQE128 sets SetupSQE128, which doubles the size of every SQE to make room for
+	// the command area of uring_cmd requests
+	SQE128 bool
+
+	// CQE32 sets SetupCQE32, which doubles the size of every CQE so requests can
+	// post the extra data that is read with CQEvent.BigCQE
+	CQE32 bool
+}
+
+// RingOption configures a RingConfig
+type RingOption func(c *RingConfig)
+
+// WithCQEntries sizes the CQ to entries
+func WithCQEntries(entries uint32) RingOption {
+	return func(c *RingConfig) {
+		c.CQEntries = entries
+	}
+}
+
+// WithClamp clamps the queue sizes to the largest the kernel supports
+func WithClamp() RingOption {
+	return func(c *RingConfig) {
+		c.Clamp = true
+	}
+}
+
+// WithSQPoll starts an SQPOLL thread that goes to sleep after idle without new requests
+func WithSQPoll(idle time.Duration) RingOption {
+	return func(c *RingConfig) {
+		c.SQPoll = true
+		c.SQThreadIdle = idle
+	}
+}
+
+// WithSQThreadCPU pins the SQPOLL thread to cpu
+func WithSQThreadCPU(cpu uint32) RingOption {
+	return func(c *RingConfig) {
+		c.SQThreadAffinity = true
+		c.SQThreadCPU = cpu
+	}
+}
+
+// WithSingleIssuer promises the kernel that only a single task submits requests, see
+// RingConfig.SingleIssuer for the restrictions this puts on the ring
+func WithSingleIssuer() RingOption {
+	return func(c *RingConfig) {
+		c.SingleIssuer = true
+	}
+}
+
+// WithDeferTaskRun defers completion work until completions are waited for, it implies WithSingleIssuer
+func WithDeferTaskRun() RingOption {
+	return func(c *RingConfig) {
+		c.SingleIssuer = true
+		c.DeferTaskRun = true
+	}
+}
+
+// WithSubmitAll keeps submitting a batch after one of its requests fails to be submitted
+func WithSubmitAll() RingOption {
+	return func(c *RingConfig) {
+		c.SubmitAll = true
+	}
+}
+
+// WithCoopTaskRun stops the kernel from interrupting the submitting task to run completion work
+func WithCoopTaskRun() RingOption {
+	return func(c *RingConfig) {
+		c.CoopTaskRun = true
+	}
+}
+
+// WithTaskRunFlag raises SQStatusTaskRun when completion work is pending
+func WithTaskRunFlag() RingOption {
+	return func(c *RingConfig) {
+		c.TaskRunFlag = true
+	}
+}
+
+// WithSQE128 doubles the size of every SQE
+func WithSQE128() RingOption {
+	return func(c *RingConfig) {
+		c.SQE128 = true
+	}
+}
+
+// WithCQE32 doubles the size of every CQE
+func WithCQE32() RingOption {
+	return func(c *RingConfig) {
+		c.CQE32 = true
+	}
+}
+
+// NewRingConfig returns a RingConfig for a ring with entries SQ entries and the given options applied
+func NewRingConfig(entries uint32, options ...RingOption) *RingConfig {
+	c := &RingConfig{
+		Entries: entries,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// ringOption is a setup flag of a RingConfig, along with the name it is reported by
+type ringOption struct {
+	name string
+	flag Setup
+}
+
+// options returns the setup flags of the config in the order they are probed in, every
+// flag comes after the flags it depends on
+func (c *RingConfig) options() []ringOption {
+	var options []ringOption
+	add := func(set bool, name string, flag Setup) {
+		if set {
+			options = append(options, ringOption{name: name, flag: flag})
+		}
+	}
+
+	add(c.CQEntries != 0, "CQEntries", SetupCQSize)
+	add(c.Clamp, "Clamp", SetupClamp)
+	add(c.SQPoll, "SQPoll", SetupSQPoll)
+	add(c.SQThreadAffinity, "SQThreadCPU", SetupSQAff)
+	add(c.SubmitAll, "SubmitAll", SetupSubmitAll)
+	add(c.CoopTaskRun, "CoopTaskRun", SetupCoopTaskRun)
+	add(c.SingleIssuer, "SingleIssuer", SetupSingleIssuer)
+	add(c.DeferTaskRun, "DeferTaskRun", SetupDeferTaskRun)
+	add(c.TaskRunFlag, "TaskRunFlag", SetupTaskRunFlag)
+	add(c.SQE128, "SQE128", SetupSQE128)
+	add(c.CQE32, "CQE32", SetupCQE32)
+
+	return options
+}
+
+// Validate checks the config for combinations of options that the kernel is known to reject
+func (c *RingConfig) Validate() error {
+	if c.Entries == 0 {
+		return fmt.Errorf("%w: Entries must not be zero", ErrInvalidRingConfig)
+	}
+	if c.Entries > MaxEntries && !c.Clamp {
+		return fmt.Errorf("%w: Entries must not exceed %d without Clamp", ErrInvalidRingConfig, MaxEntries)
+	}
+	if c.CQEntries != 0 {
+		// The kernel rounds both sizes up to a power of 2 before comparing them
+		if roundUpPow2(c.CQEntries) < roundUpPow2(c.Entries) {
+			return fmt.Errorf("%w: CQEntries must be at least Entries", ErrInvalidRingConfig)
+		}
+		if c.CQEntries > MaxCQEntries && !c.Clamp {
+			return fmt.Errorf("%w: CQEntries must not exceed %d without Clamp", ErrInvalidRingConfig, MaxCQEntries)
+		}
+	}
+
+	if !c.SQPoll {
+		if c.SQThreadAffinity {
+			return fmt.Errorf("%w: SQThreadCPU requires SQPoll", ErrInvalidRingConfig)
+		}
+		if c.SQThreadIdle != 0 {
+			return fmt.Errorf("%w: SQThreadIdle requires SQPoll", ErrInvalidRingConfig)
+		}
+	} else if c.CoopTaskRun || c.TaskRunFlag || c.DeferTaskRun {
+		return fmt.Errorf("%w: CoopTaskRun, TaskRunFlag and DeferTaskRun cannot be combined with SQPoll", ErrInvalidRingConfig)
+	}
+
+	if c.DeferTaskRun && !c.SingleIssuer {
+		return fmt.Errorf("%w: DeferTaskRun requires SingleIssuer", ErrInvalidRingConfig)
+	}
+	if c.TaskRunFlag && !c.CoopTaskRun && !c.DeferTaskRun {
+		return fmt.Errorf("%w: TaskRunFlag requires CoopTaskRun or DeferTaskRun", ErrInvalidRingConfig)
+	}
+
+	return nil
+}
+
+// roundUpPow2 returns the smallest power of 2 that is at least n
+func roundUpPow2(n uint32) uint64 {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len32(n-1)
+}
+
+// Params validates the config and returns the Params it describes
+func (c *RingConfig) Params() (*Params, error) {
+	err := c.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	params := &Params{
+		CQEntries:    c.CQEntries,
+		SQThreadCPU:  c.SQThreadCPU,
+		SQThreadIdle: uint32(c.SQThreadIdle / time.Millisecond),
+	}
+	for _, option := range c.options() {
+		params.Flags |= uint32(option.flag)
+	}
+
+	return params, nil
+}
+
+// rejected finds the option the kernel rejected with errno, by setting up throwaway rings
+// that add the options of the config one at a time until setting one up fails the same way
+func (c *RingConfig) rejected(errno syscall.Errno) (ringOption, bool) {
+	params := Params{
+		CQEntries:    c.CQEntries,
+		SQThreadCPU:  c.SQThreadCPU,
+		SQThreadIdle: uint32(c.SQThreadIdle / time.Millisecond),
+	}
+	if probeSetup(c.Entries, params) == errno {
+		return ringOption{name: "Entries"}, true
+	}
+
+	for _, option := range c.options() {
+		params.Flags |= uint32(option.flag)
+		if probeSetup(c.Entries, params) == errno {
+			return option, true
+		}
+	}
+
+	return ringOption{}, false
+}
+
+// probeSetup sets up a ring with params and immediately closes it again
+func probeSetup(entries uint32, params Params) syscall.Errno {
+	fd, _, errno := syscall.Syscall(SYS_IO_URING_SETUP, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if errno == 0 {
+		_ = syscall.Close(int(fd))
+	}
+	return errno
+}
+
+// RingConfigError is returned by QueueInitConfig when the kernel rejects one of the options
+// of a RingConfig, Flag is the setup flag the option sets, if any
+type RingConfigError struct {
+	Option string
+	Flag   Setup
+	Err    error
+}
+
+func (e *RingConfigError) Error() string {
+	if e.Flag != 0 {
+		return fmt.Sprintf("kernel rejected ring option %s (%s requires Linux %s): %v", e.Option, e.Flag, e.Flag.MinKernel(), e.Err)
+	}
+	return fmt.Sprintf("kernel rejected ring option %s: %v", e.Option, e.Err)
+}
+
+func (e *RingConfigError) Unwrap() error {
+	return e.Err
+}
+
+// QueueInitConfig validates config and sets up the ring it describes
+//
+// If the kernel rejects the config, the returned error is a *RingConfigError naming the
+// option that was rejected whenever it can be narrowed down to a single one.
+func (r *Ring) QueueInitConfig(config *RingConfig) error {
+	params, err := config.Params()
+	if err != nil {
+		return err
+	}
+
+	err = r.QueueInitParams(config.Entries, params)
+	if err != nil {
+		var errno syscall.Errno
+		if errors.As(err, &errno) {
+			if option, ok := config.rejected(errno); ok {
+				return &RingConfigError{Option: option.name, Flag: option.flag, Err: err}
+			}
+		}
+		return err
+	}
+
+	return nil
+}
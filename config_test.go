@@ -0,0 +1,87 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestRingConfigValidate(t *testing.T) {
+	invalid := []*RingConfig{
+		NewRingConfig(0),
+		NewRingConfig(MaxEntries + 1),
+		NewRingConfig(64, WithCQEntries(32)),
+		NewRingConfig(17, WithCQEntries(16)),
+		NewRingConfig(64, WithSQThreadCPU(0)),
+		NewRingConfig(64, WithSQPoll(time.Second), WithCoopTaskRun()),
+		NewRingConfig(64, WithSQPoll(time.Second), WithDeferTaskRun()),
+		NewRingConfig(64, WithTaskRunFlag()),
+		{Entries: 64, DeferTaskRun: true},
+		{Entries: 64, SQThreadIdle: time.Second},
+	}
+	for _, config := range invalid {
+		require.ErrorIs(t, config.Validate(), ErrInvalidRingConfig, "%+v", config)
+	}
+
+	valid := []*RingConfig{
+		NewRingConfig(MaxEntries+1, WithClamp()),
+		NewRingConfig(64, WithCQEntries(256)),
+		NewRingConfig(12, WithCQEntries(10)),
+		NewRingConfig(64, WithSQPoll(time.Second), WithSQThreadCPU(0)),
+		NewRingConfig(64, WithDeferTaskRun(), WithTaskRunFlag()),
+		NewRingConfig(64, WithCoopTaskRun(), WithTaskRunFlag(), WithSubmitAll()),
+	}
+	for _, config := range valid {
+		require.NoError(t, config.Validate(), "%+v", config)
+	}
+
+	params, err := NewRingConfig(64, WithCQEntries(256), WithSQPoll(1500*time.Millisecond), WithSQThreadCPU(1)).Params()
+	require.NoError(t, err)
+	require.Equal(t, uint32(SetupCQSize|SetupSQPoll|SetupSQAff), params.Flags)
+	require.Equal(t, uint32(256), params.CQEntries)
+	require.Equal(t, uint32(1500), params.SQThreadIdle)
+	require.Equal(t, uint32(1), params.SQThreadCPU)
+}
+
+func TestRingQueueInitConfig(t *testing.T) {
+	ring, err := NewRing()
+	require.NoError(t, err)
+
+	err = ring.QueueInitConfig(NewRingConfig(8, WithCQEntries(64), WithSubmitAll()))
+	require.NoError(t, err)
+	require.Equal(t, uint32(8), ring.SQ.RingEntries)
+	require.Equal(t, uint32(64), ring.CQ.RingEntries)
+	require.NoError(t, ring.Close())
+
+	// Both sizes are rounded up to 16 before the kernel compares them
+	ring, err = NewRing()
+	require.NoError(t, err)
+	require.NoError(t, ring.QueueInitConfig(NewRingConfig(12, WithCQEntries(10))))
+	require.Equal(t, uint32(16), ring.CQ.RingEntries)
+	require.NoError(t, ring.Close())
+
+	ring, err = NewRing()
+	require.NoError(t, err)
+
+	err = ring.QueueInitConfig(NewRingConfig(8, WithSQPoll(time.Millisecond), WithSQThreadCPU(1<<20)))
+	var configErr *RingConfigError
+	require.ErrorAs(t, err, &configErr)
+	require.Equal(t, "SQThreadCPU", configErr.Option)
+	require.Equal(t, SetupSQAff, configErr.Flag)
+}
@@ -0,0 +1,643 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var _ net.Conn = (*Conn)(nil)
+
+const (
+	ConnEntries = 8
+)
+
+// deadline is the deadline for one direction of a Conn, the timespec that is linked to
+// the requests of that direction while it is set, the tokens of the requests of that
+// direction that are in flight, and whether the deadline moved while they were
+type deadline struct {
+	t      time.Time
+	ts     KernelTimespec
+	tokens []uint64
+	moved  bool
+}
+
+// Conn is a net.Conn whose reads, writes and close are all submitted to an io_uring Ring
+//
+// Every Conn owns a small ring whose completions are routed back to the waiting Read,
+// Write or Close call by a Dispatcher, unless it was created by a RingGroup, in which
+// case it shares the ring of one of the group's shards. Connections accepted by a direct
+// Listener share the listener's ring, and refer to their socket by its slot in the ring's
+// file table rather than by a file descriptor.
+//
+// Deadlines are enforced by the kernel, every request submitted while a deadline is set
+// is followed by a linked timeout that cancels it once the deadline has passed. Moving a
+// deadline cancels the requests that are in flight, which are then submitted again with
+// a timeout for the new deadline.
+type Conn struct {
+	fd         int
+	network    string
+	dispatcher *Dispatcher
+	shared     bool
+	direct     *directRing
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	readMu   sync.Mutex
+	readBuf  []byte
+	readMsgs []*message
+
+	writeMu   sync.Mutex
+	writeBuf  []byte
+	writeMsgs []*message
+
+	submitMu      sync.Mutex
+	readDeadline  deadline
+	writeDeadline deadline
+	closed        chan struct{}
+}
+
+// Dial connects to addr, submitting the connect request through a new Ring,
+// network must be "tcp", "tcp4" or "tcp6"
+func Dial(network string, addr string) (*Conn, error) {
+	return DialContext(context.Background(), network, addr)
+}
+
+// DialContext is like Dial, but cancels the connect request if ctx is done before
+// the connection has been established
+func DialContext(ctx context.Context, network string, addr string) (*Conn, error) {
+	tcpAddr, err := net.ResolveTCPAddr(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("error while resolving dial address: %w", err)
+	}
+	if len(tcpAddr.IP) == 0 {
+		if network == "tcp6" {
+			tcpAddr.IP = net.IPv6loopback
+		} else {
+			tcpAddr.IP = net.IPv4(127, 0, 0, 1)
+		}
+	}
+
+	family, ipv6only := ipFamily(network, tcpAddr.IP, false)
+	fd, err := openIPSocket(family, syscall.SOCK_STREAM, ipv6only)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening dialing socket: %w", err)
+	}
+
+	dispatcher, err := newConnDispatcher(fd)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+
+	remoteAddress := NewClientAddress()
+	err = remoteAddress.SetTCPAddr(family, tcpAddr)
+	if err == nil {
+		err = connect(ctx, dispatcher, fd, remoteAddress)
+	}
+	if err != nil {
+		_ = dispatcher.Close()
+		_ = syscall.Close(fd)
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: tcpAddr, Err: err}
+	}
+
+	conn, err := newConn(fd, network, dispatcher, remoteAddress.Addr(network))
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// NewConn wraps an already connected TCP socket, taking ownership of fd
+func NewConn(fd int, remoteAddr *net.TCPAddr) (*Conn, error) {
+	if remoteAddr == nil {
+		return newConnFromFD(fd, "tcp", nil)
+	}
+	return newConnFromFD(fd, "tcp", remoteAddr)
+}
+
+func newConnFromFD(fd int, network string, remoteAddr net.Addr) (*Conn, error) {
+	dispatcher, err := newConnDispatcher(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	return newConn(fd, network, dispatcher, remoteAddr)
+}
+
+func newConnDispatcher(fd int) (*Dispatcher, error) {
+	ring, err := NewRing()
+	if err != nil {
+		return nil, fmt.Errorf("error while creating ring for socket with fd %d: %w", fd, err)
+	}
+
+	err = ring.QueueInit(ConnEntries, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error while initializing ring for socket with fd %d: %w", fd, err)
+	}
+
+	return NewDispatcher(ring), nil
+}
+
+// newConn wraps fd in a Conn that takes ownership of dispatcher, which is closed if the Conn
+// cannot be created
+func newConn(fd int, network string, dispatcher *Dispatcher, remoteAddr net.Addr) (*Conn, error) {
+	c, err := newSharedConn(fd, network, dispatcher, remoteAddr)
+	if err != nil {
+		_ = dispatcher.Close()
+		return nil, err
+	}
+	c.shared = false
+
+	return c, nil
+}
+
+// newSharedConn wraps fd in a Conn that submits its requests through dispatcher
+// without taking ownership of it
+func newSharedConn(fd int, network string, dispatcher *Dispatcher, remoteAddr net.Addr) (*Conn, error) {
+	sa, err := syscall.Getsockname(fd)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting local address of socket with fd %d: %w", fd, err)
+	}
+
+	return &Conn{
+		fd:         fd,
+		network:    network,
+		dispatcher: dispatcher,
+		shared:     true,
+		localAddr:  sockaddrToAddr(network, sa),
+		remoteAddr: remoteAddr,
+		closed:     make(chan struct{}),
+	}, nil
+}
+
+// connect submits a connect request and waits for it to complete, if ctx is done
+// first the connect request is cancelled and ctx.Err() is returned
+func connect(ctx context.Context, dispatcher *Dispatcher, fd int, remoteAddress *ClientAddress) error {
+	c, err := dispatcher.Do(ctx, func(sqe *SQEntry) {
+		sqe.PrepareConnect(fd, remoteAddress.AddressPointer, *remoteAddress.Length)
+	})
+	if err != nil {
+		return err
+	}
+	if c.Res < 0 {
+		return os.NewSyscallError("connect", syscall.Errno(-c.Res))
+	}
+
+	return nil
+}
+
+// Read submits a recv request for b and waits for it to complete
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.ReadContext(context.Background(), b)
+}
+
+// ReadContext is like Read, but cancels the recv request if ctx is done before it
+// completes, in which case ctx.Err() is returned
+func (c *Conn) ReadContext(ctx context.Context, b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if len(b) > math.MaxInt32 {
+		b = b[:math.MaxInt32]
+	}
+	if len(b) == 0 {
+		if c.isClosed() {
+			return 0, c.opError("read", net.ErrClosed)
+		}
+		return 0, nil
+	}
+
+	c.readBuf = b
+	res, err := c.do(ctx, EventRead, func(sqe *SQEntry) {
+		sqe.PrepareRecv(c.fd, uintptr(unsafe.Pointer(&b[0])), uint32(len(b)), 0)
+	})
+	c.readBuf = nil
+	if err != nil {
+		return 0, c.opError("read", err)
+	}
+	if res < 0 {
+		return 0, c.opError("read", c.resError("recv", res))
+	}
+	if res == 0 && isConnectionOriented(c.network) {
+		if c.isClosed() {
+			return 0, c.opError("read", net.ErrClosed)
+		}
+		return 0, io.EOF
+	}
+
+	return int(res), nil
+}
+
+// Write submits send requests until all of b has been written
+func (c *Conn) Write(b []byte) (int, error) {
+	return c.WriteContext(context.Background(), b)
+}
+
+// WriteContext is like Write, but cancels the pending send request if ctx is done
+// before all of b has been written, in which case ctx.Err() is returned
+func (c *Conn) WriteContext(ctx context.Context, b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if len(b) == 0 && c.isClosed() {
+		return 0, c.opError("write", net.ErrClosed)
+	}
+
+	var n int
+	for n < len(b) {
+		p := b[n:]
+		if len(p) > math.MaxInt32 {
+			p = p[:math.MaxInt32]
+		}
+
+		c.writeBuf = p
+		res, err := c.do(ctx, EventWrite, func(sqe *SQEntry) {
+			sqe.PrepareSend(c.fd, uintptr(unsafe.Pointer(&p[0])), uint32(len(p)), syscall.MSG_NOSIGNAL)
+		})
+		c.writeBuf = nil
+		if err != nil {
+			return n, c.opError("write", err)
+		}
+		if res < 0 {
+			return n, c.opError("write", c.resError("send", res))
+		}
+
+		n += int(res)
+	}
+
+	return n, nil
+}
+
+// Close shuts the socket down, which completes any pending reads or writes,
+// closes it through the ring and then tears down the ring itself, unless the
+// ring is shared with other connections
+func (c *Conn) Close() error {
+	c.submitMu.Lock()
+	if c.isClosed() {
+		c.submitMu.Unlock()
+		return c.opError("close", net.ErrClosed)
+	}
+	close(c.closed)
+
+	closed := NewFuture()
+	err := c.dispatcher.Submit(func(s *Submission) error {
+		sqe, err := s.Entry(nil)
+		if err != nil {
+			return err
+		}
+		sqe.PrepareShutdown(c.fd, syscall.SHUT_RDWR)
+		if c.direct != nil {
+			sqe.SetFixedFile(uint32(c.fd))
+		}
+		sqe.Flags |= uint8(SQEntryFlagIOHardLink)
+
+		sqe, err = s.Entry(closed)
+		if err != nil {
+			return err
+		}
+		if c.direct != nil {
+			sqe.PrepareCloseDirect(uint32(c.fd))
+		} else {
+			sqe.PrepareClose(c.fd)
+		}
+		return nil
+	})
+	c.submitMu.Unlock()
+	if err != nil {
+		if c.direct != nil {
+			_ = c.direct.files.Free(uint32(c.fd))
+		} else {
+			_ = syscall.Close(c.fd)
+		}
+		_ = c.release()
+		return c.opError("close", fmt.Errorf("error while submitting close SQE for socket with fd %d: %w", c.fd, err))
+	}
+
+	<-closed.Done()
+
+	err = c.release()
+	if res := closed.Result().Res; res < 0 {
+		return c.opError("close", os.NewSyscallError("close", syscall.Errno(-res)))
+	}
+	if err != nil {
+		return c.opError("close", fmt.Errorf("error while closing dispatcher for socket with fd %d: %w", c.fd, err))
+	}
+
+	return nil
+}
+
+// release gives up the connection's hold on its ring, which closes the ring if it is
+// owned by the connection or if the connection was the last user of a direct ring
+func (c *Conn) release() error {
+	switch {
+	case c.direct != nil:
+		return c.direct.release()
+	case !c.shared:
+		return c.dispatcher.Close()
+	}
+	return nil
+}
+
+// File returns a regular file descriptor for the connection's socket as an *os.File, closing
+// either the file or the connection leaves the other one open
+//
+// The socket of a connection accepted by a direct Listener is installed into the process fd
+// table with a fixed fd install request, which requires IORING_OP_FIXED_FD_INSTALL (Linux 6.8).
+func (c *Conn) File() (*os.File, error) {
+	if c.isClosed() {
+		return nil, c.opError("file", net.ErrClosed)
+	}
+
+	var fd int
+	if c.direct != nil {
+		cpl, err := c.dispatcher.Do(context.Background(), func(sqe *SQEntry) {
+			sqe.PrepareFixedFDInstall(uint32(c.fd), 0)
+		})
+		if err != nil {
+			return nil, c.opError("file", err)
+		}
+		if cpl.Res < 0 {
+			return nil, c.opError("file", os.NewSyscallError("fixed_fd_install", syscall.Errno(-cpl.Res)))
+		}
+		fd = int(cpl.Res)
+	} else {
+		var err error
+		fd, err = unix.FcntlInt(uintptr(c.fd), unix.F_DUPFD_CLOEXEC, 0)
+		if err != nil {
+			return nil, c.opError("file", os.NewSyscallError("fcntl", err))
+		}
+	}
+
+	return os.NewFile(uintptr(fd), fmt.Sprintf("%s %s->%s", c.network, c.localAddr, c.remoteAddr)), nil
+}
+
+// LocalAddr returns the local address of the connection
+func (c *Conn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+// RemoteAddr returns the address of the connection's peer
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// SetDeadline sets both the read and the write deadline of the connection
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.setDeadline(t, EventRead, EventWrite)
+}
+
+// SetReadDeadline sets the deadline for future and pending reads
+//
+// Reads that exceed the deadline fail with an error wrapping os.ErrDeadlineExceeded,
+// and a zero t means reads will not time out.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.setDeadline(t, EventRead)
+}
+
+// SetWriteDeadline sets the deadline for future and pending writes
+//
+// Writes that exceed the deadline fail with an error wrapping os.ErrDeadlineExceeded,
+// and a zero t means writes will not time out.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.setDeadline(t, EventWrite)
+}
+
+// setDeadline stores t as the deadline for the requests tagged with events, and cancels
+// the requests that are already in flight if t differs from their deadline, so do and
+// doBatch submit them again with the new deadline
+func (c *Conn) setDeadline(t time.Time, events ...Event) error {
+	c.submitMu.Lock()
+	defer c.submitMu.Unlock()
+	if c.isClosed() {
+		return c.opError("set", net.ErrClosed)
+	}
+
+	for _, event := range events {
+		d := c.deadline(event)
+		if d.t.Equal(t) {
+			continue
+		}
+		d.t = t
+		if len(d.tokens) == 0 {
+			continue
+		}
+
+		d.moved = true
+		for _, token := range d.tokens {
+			_, err := c.dispatcher.Cancel(token)
+			if err != nil {
+				return c.opError("set", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Conn) deadline(event Event) *deadline {
+	if event == EventWrite {
+		return &c.writeDeadline
+	}
+	return &c.readDeadline
+}
+
+// do submits a single request and waits for its result, the caller must hold the lock for the
+// direction the request belongs to and keep everything the request points to alive until do returns
+//
+// If ctx is done before the request completes, it is cancelled and do returns ctx.Err() once the
+// kernel is no longer using the memory the request points to.
+func (c *Conn) do(ctx context.Context, event Event, prepare func(sqe *SQEntry)) (int32, error) {
+	err := ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		futures, err := c.submit(event, 1, func(_ int, sqe *SQEntry) {
+			prepare(sqe)
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		cpl, err := futures[0].Wait(ctx)
+		if c.finish(event) && err == nil && isCancelled(cpl.Res) {
+			continue
+		}
+		return cpl.Res, err
+	}
+}
+
+// doBatch submits n requests as a single linked chain, so they execute in order and a failing
+// request cancels the rest of the chain, and waits for all of them to complete, returning their
+// results in submission order
+func (c *Conn) doBatch(event Event, n int, prepare func(index int, sqe *SQEntry)) ([]int32, error) {
+	res := make([]int32, n)
+	for done := 0; done < n; {
+		offset := done
+		futures, err := c.submit(event, n-offset, func(index int, sqe *SQEntry) {
+			prepare(offset+index, sqe)
+		})
+		if err != nil {
+			if offset == 0 {
+				return nil, err
+			}
+			// The new deadline has already passed, so the rest of the chain stays cancelled
+			return res, nil
+		}
+
+		for i := range futures {
+			<-futures[i].Done()
+			res[offset+i] = futures[i].Result().Res
+		}
+
+		done = n
+		if c.finish(event) {
+			// The chain is submitted again from the first request the deadline change cancelled
+			for i := offset; i < n; i++ {
+				if isCancelled(res[i]) {
+					done = i
+					break
+				}
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// finish records that the requests of the direction of event are no longer in flight, and
+// reports whether the deadline of that direction moved while they were
+func (c *Conn) finish(event Event) bool {
+	c.submitMu.Lock()
+	defer c.submitMu.Unlock()
+
+	d := c.deadline(event)
+	moved := d.moved
+	d.tokens, d.moved = d.tokens[:0], false
+
+	return moved
+}
+
+// submit prepares n requests for the direction of event as a single linked chain and submits
+// them, if a deadline is set for that direction every request is followed by a linked timeout
+// that expires at the deadline
+func (c *Conn) submit(event Event, n int, prepare func(index int, sqe *SQEntry)) ([]*Future, error) {
+	c.submitMu.Lock()
+	defer c.submitMu.Unlock()
+	if c.isClosed() {
+		return nil, net.ErrClosed
+	}
+
+	d := c.deadline(event)
+	timeout := !d.t.IsZero()
+	if timeout {
+		remaining := time.Until(d.t)
+		if remaining <= 0 {
+			return nil, os.ErrDeadlineExceeded
+		}
+		d.ts = KernelTimespec{
+			Sec:  int64(remaining / time.Second),
+			Nsec: int64(remaining % time.Second),
+		}
+	}
+
+	futures := make([]*Future, n)
+	err := c.dispatcher.Submit(func(s *Submission) error {
+		d.tokens = d.tokens[:0]
+		for i := 0; i < n; i++ {
+			futures[i] = NewFuture()
+			sqe, err := s.Entry(futures[i])
+			if err != nil {
+				return err
+			}
+			prepare(i, sqe)
+			if c.direct != nil {
+				sqe.SetFixedFile(uint32(c.fd))
+			}
+			if i < n-1 || timeout {
+				sqe.Flags |= uint8(SQEntryFlagIOLink)
+			}
+			d.tokens = append(d.tokens, sqe.UserData)
+
+			if timeout {
+				sqe, err = s.Entry(nil)
+				if err != nil {
+					return err
+				}
+				sqe.PrepareLinkTimeout(&d.ts, 0)
+				if i < n-1 {
+					sqe.Flags |= uint8(SQEntryFlagIOLink)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		d.tokens = d.tokens[:0]
+		return nil, err
+	}
+	d.moved = false
+
+	return futures, nil
+}
+
+func (c *Conn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// resError converts the negative result of a request into an error, requests are only
+// cancelled by a linked timeout or by a deadline that was moved into the past, since those
+// cancelled by any other deadline change are submitted again, so a cancelled request means
+// its deadline was exceeded
+func (c *Conn) resError(syscallName string, res int32) error {
+	if c.isClosed() {
+		return net.ErrClosed
+	}
+	if isCancelled(res) {
+		return os.ErrDeadlineExceeded
+	}
+	return os.NewSyscallError(syscallName, syscall.Errno(-res))
+}
+
+// isCancelled reports whether res is the result of a request that was cancelled, requests
+// that the kernel had already started executing are interrupted rather than cancelled
+func isCancelled(res int32) bool {
+	return res == -int32(syscall.ECANCELED) || res == -int32(syscall.EINTR)
+}
+
+func (c *Conn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: c.network, Source: c.localAddr, Addr: c.remoteAddr, Err: err}
+}
@@ -0,0 +1,126 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// directRing is the ring a direct Listener shares with the connections it accepted, whose
+// file table holds the direct descriptors of all of them
+//
+// The listener and every open connection hold a reference, and the last one to be closed
+// unregisters the file table and closes the ring.
+type directRing struct {
+	dispatcher *Dispatcher
+	files      *FileTable
+	refs       atomic.Int32
+}
+
+// acquire takes a reference to the ring, it fails once the last reference has been released
+func (r *directRing) acquire() bool {
+	for {
+		refs := r.refs.Load()
+		if refs == 0 {
+			return false
+		}
+		if r.refs.CompareAndSwap(refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// release drops a reference to the ring, and closes it when it was the last one
+func (r *directRing) release() error {
+	if r.refs.Add(-1) > 0 {
+		return nil
+	}
+
+	// Unregistering the table first makes any late Free fail instead of
+	// updating the file table of a ring that reuses the same fd
+	_ = r.files.Unregister()
+	return r.dispatcher.Close()
+}
+
+// newDirectListener starts listening on the already bound socket fd with a ring whose file table
+// has slots entries, all of which are reserved for the connections accepted by the listener
+func newDirectListener(fd int, network string, slots uint32) (*Listener, error) {
+	ring, err := NewRing()
+	if err != nil {
+		return nil, fmt.Errorf("error while creating ring for listening socket with fd %d: %w", fd, err)
+	}
+
+	err = ring.QueueInit(DirectEntries, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error while initializng ring for listening socket with fd %d: %w", fd, err)
+	}
+
+	files, err := ring.RegisterFileTable(slots)
+	if err == nil {
+		err = files.SetAllocRange(0, slots)
+	}
+	if err != nil {
+		_ = ring.Close()
+		return nil, fmt.Errorf("error while registering file table for listening socket with fd %d: %w", fd, err)
+	}
+
+	direct := &directRing{
+		dispatcher: NewDispatcher(ring),
+		files:      files,
+	}
+	direct.refs.Store(1)
+
+	l := &Listener{
+		fd:         fd,
+		network:    network,
+		dispatcher: direct.dispatcher,
+		direct:     direct,
+		queue:      newAcceptQueue(AcceptEntries / 2),
+	}
+	l.newConn = func(slot int, addr net.Addr) (net.Conn, error) {
+		return newDirectConn(slot, network, direct, l.addr, addr)
+	}
+
+	err = l.start()
+	if err != nil {
+		_ = direct.release()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// newDirectConn wraps the direct descriptor in slot in a Conn that submits its requests to
+// the ring of direct, the local address cannot be looked up so it is the listener's address
+func newDirectConn(slot int, network string, direct *directRing, localAddr net.Addr, remoteAddr net.Addr) (*Conn, error) {
+	if !direct.acquire() {
+		return nil, net.ErrClosed
+	}
+
+	return &Conn{
+		fd:         slot,
+		network:    network,
+		dispatcher: direct.dispatcher,
+		shared:     true,
+		direct:     direct,
+		localAddr:  localAddr,
+		remoteAddr: remoteAddr,
+		closed:     make(chan struct{}),
+	}, nil
+}
@@ -0,0 +1,179 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func openFDs(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	require.NoError(t, err)
+	return len(entries)
+}
+
+func TestDirectEntries(t *testing.T) {
+	ring := newTestRing(t, 8)
+
+	table, err := ring.RegisterFileTable(4)
+	require.NoError(t, err)
+	require.NoError(t, table.SetAllocRange(2, 2))
+	require.ErrorIs(t, table.SetAllocRange(3, 2), ErrInvalidFileSlot)
+
+	complete := func() *CQEvent {
+		_, err := ring.Submit()
+		require.NoError(t, err)
+		cqe, err := ring.WaitCQEventTimeout(time.Second)
+		require.NoError(t, err)
+		ring.CQESeen(cqe)
+		return cqe
+	}
+
+	sqe := ring.GetSQEntry()
+	sqe.PrepareSocketDirect(syscall.AF_INET, syscall.SOCK_STREAM, 0, 0, FileIndexAlloc)
+	cqe := complete()
+	require.Equal(t, int32(2), cqe.Res, "socket should be allocated in the allocation range")
+
+	// Register skips the slots that are reserved for the kernel
+	for i := uint32(0); i < 2; i++ {
+		slot, err := table.Register(0, 0)
+		require.NoError(t, err)
+		require.Equal(t, i, slot)
+	}
+	_, err = table.Register(0, 0)
+	require.ErrorIs(t, err, ErrFileTableFull)
+
+	path, err := syscall.BytePtrFromString("/dev/null")
+	require.NoError(t, err)
+	sqe = ring.GetSQEntry()
+	sqe.PrepareOpenatDirect(unix.AT_FDCWD, path, syscall.O_RDONLY, 0, 1)
+	cqe = complete()
+	require.Zero(t, cqe.Res)
+
+	sqe = ring.GetSQEntry()
+	sqe.PrepareFixedFDInstall(2, 0)
+	cqe = complete()
+	require.GreaterOrEqual(t, cqe.Res, int32(0))
+	fd := int(cqe.Res)
+	sotype, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+	require.NoError(t, err)
+	require.Equal(t, syscall.SOCK_STREAM, sotype)
+	require.NoError(t, syscall.Close(fd))
+
+	sqe = ring.GetSQEntry()
+	sqe.PrepareCloseDirect(2)
+	cqe = complete()
+	require.Zero(t, cqe.Res)
+
+	sqe = ring.GetSQEntry()
+	sqe.PrepareFixedFDInstall(2, 0)
+	cqe = complete()
+	require.Equal(t, -int32(syscall.EBADF), cqe.Res)
+}
+
+func TestListenDirect(t *testing.T) {
+	l, err := ListenDirect("tcp", "127.0.0.1:0", 16)
+	require.NoError(t, err)
+
+	const clients = 8
+	clientConns := make([]net.Conn, clients)
+	for i := range clientConns {
+		clientConns[i], err = net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+	}
+
+	before := openFDs(t)
+	conns := make([]net.Conn, clients)
+	for i := range conns {
+		conns[i], err = l.Accept()
+		require.NoError(t, err)
+		require.Equal(t, l.Addr(), conns[i].LocalAddr())
+		require.NotNil(t, conns[i].RemoteAddr())
+	}
+	require.Equal(t, before, openFDs(t), "direct connections must not use the process fd table")
+
+	buf := make([]byte, 5)
+	for i := range conns {
+		_, err = clientConns[i].Write([]byte("hello"))
+		require.NoError(t, err)
+
+		n, err := conns[i].Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(buf[:n]))
+
+		_, err = conns[i].Write([]byte("world"))
+		require.NoError(t, err)
+
+		_, err = clientConns[i].Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, "world", string(buf))
+	}
+
+	f, err := conns[0].(*Conn).File()
+	require.NoError(t, err)
+	sa, err := syscall.Getpeername(int(f.Fd()))
+	require.NoError(t, err)
+	require.Equal(t, clientConns[0].LocalAddr().String(), sockaddrToAddr("tcp", sa).String())
+	require.NoError(t, f.Close())
+
+	// The ring stays open for the connections after the listener has been closed
+	require.NoError(t, l.Close())
+	for i := range conns {
+		_, err = clientConns[i].Write([]byte("hello"))
+		require.NoError(t, err)
+		n, err := conns[i].Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(buf[:n]))
+
+		require.NoError(t, conns[i].Close())
+		_ = clientConns[i].Close()
+	}
+}
+
+func TestListenDirectFullQueue(t *testing.T) {
+	l, err := ListenDirect("tcp", "127.0.0.1:0", 512)
+	require.NoError(t, err)
+
+	client, server, pending := fillAcceptQueue(t, l)
+
+	// Accepting more connections than the queue holds requires the listener to resume accepting
+	for i := 0; i < len(pending)-AcceptEntries/16; i++ {
+		c, err := l.Accept()
+		require.NoError(t, err)
+		require.NoError(t, c.Close())
+	}
+
+	// Closing the listener closes the direct descriptors of the connections it never returned
+	require.NoError(t, l.Close())
+	buf := make([]byte, 1)
+	for _, c := range pending {
+		require.NoError(t, c.SetReadDeadline(time.Now().Add(5*time.Second)))
+		_, err = c.Read(buf)
+		require.Error(t, err)
+		require.False(t, os.IsTimeout(err), "accepted connection was not closed")
+		require.NoError(t, c.Close())
+	}
+
+	require.NoError(t, server.Close())
+	require.NoError(t, client.Close())
+}
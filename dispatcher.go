@@ -0,0 +1,595 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+const (
+	// dispatcherBatchSize is the largest number of completions a Dispatcher reaps at once
+	dispatcherBatchSize = 64
+
+	// TagFlag is set in the UserData of the CQEs posted for tagged slots of a BufferTable or
+	// FileTable, which keeps them apart from the tokens of a Dispatcher since those never reach it
+	TagFlag uint64 = 1 << 63
+
+	// FileTagFlag is set in addition to TagFlag for the tagged slots of a FileTable
+	FileTagFlag uint64 = 1 << 62
+)
+
+var (
+	ErrDispatcherClosed = errors.New("dispatcher is closed")
+	ErrInvalidTag       = errors.New("tag overlaps the reserved tag bits")
+)
+
+// Completion is the result of a request, as reported by the CQEvent it completed with
+type Completion struct {
+	Res   int32
+	Flags uint32
+}
+
+// More reports whether more completions will follow for the same request, which
+// is the case for multishot requests until the kernel terminates them
+func (c Completion) More() bool {
+	return CQEventFlag(c.Flags)&CQEventFlagMore != 0
+}
+
+// BufferID returns the ID of the provided buffer a buffer select request picked, the
+// second result is false if the request completed without picking a buffer
+func (c Completion) BufferID() (uint16, bool) {
+	return CQEventFlag(c.Flags).BufferID()
+}
+
+// Err returns the errno of a failed request, or nil if the request succeeded
+func (c Completion) Err() error {
+	if c.Res < 0 {
+		return syscall.Errno(-c.Res)
+	}
+	return nil
+}
+
+// Receiver receives the completions of a request submitted through a Dispatcher
+//
+// Deliver is called from the dispatcher's goroutine, once for a single-shot request
+// and once for every completion of a multishot request, so it must not block for
+// longer than it is acceptable to hold up the completions of every other request.
+// Requests are submitted from Deliver with Dispatcher.Post, since Submit waits for the
+// submitter, which may itself be waiting for the dispatcher to reap completions.
+type Receiver interface {
+	Deliver(c Completion)
+}
+
+// ReceiverFunc is a Receiver that calls the function for every completion
+type ReceiverFunc func(c Completion)
+
+// Deliver calls f with c
+func (f ReceiverFunc) Deliver(c Completion) {
+	f(c)
+}
+
+// Future is a Receiver for the completion of a single-shot request
+type Future struct {
+	done       chan struct{}
+	completion Completion
+
+	d     *Dispatcher
+	token uint64
+}
+
+// NewFuture returns a Future that can be passed to Submission.Entry
+func NewFuture() *Future {
+	return &Future{
+		done: make(chan struct{}),
+	}
+}
+
+// Deliver stores c and marks the future as done once the final completion arrives
+func (f *Future) Deliver(c Completion) {
+	f.completion = c
+	if !c.More() {
+		close(f.done)
+	}
+}
+
+// Done returns a channel that is closed once the request has completed
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result returns the completion of the request, it must only be called after Done is closed
+func (f *Future) Result() Completion {
+	return f.completion
+}
+
+// Wait blocks until the request has completed and returns its completion
+//
+// If ctx is done first the request is cancelled, and Wait returns ctx.Err() once both the
+// request and the cancel request have completed, so the kernel is no longer using any memory
+// the request points to. A request that completed before it could be cancelled returns its
+// completion as usual.
+func (f *Future) Wait(ctx context.Context) (Completion, error) {
+	select {
+	case <-f.done:
+		return f.completion, nil
+	case <-ctx.Done():
+	}
+
+	cancel, err := f.d.Cancel(f.token)
+	if err == nil {
+		<-cancel.Done()
+	}
+	<-f.done
+
+	if isCancelled(f.completion.Res) {
+		return f.completion, ctx.Err()
+	}
+	return f.completion, nil
+}
+
+// Stream is a Receiver that forwards every completion of a multishot request to C,
+// which is closed after the final completion
+//
+// Deliver never blocks, completions that arrive while C is full are queued in memory and
+// forwarded in order by a goroutine of their own, so the queue grows for as long as C is not read.
+type Stream struct {
+	C chan Completion
+
+	mu         sync.Mutex
+	backlog    []Completion
+	forwarding bool
+}
+
+// NewStream returns a Stream whose channel buffers up to size completions
+func NewStream(size int) *Stream {
+	return &Stream{
+		C: make(chan Completion, size),
+	}
+}
+
+// Deliver forwards c to C, and closes C once the final completion arrives
+func (s *Stream) Deliver(c Completion) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.forwarding {
+		select {
+		case s.C <- c:
+			if !c.More() {
+				close(s.C)
+			}
+			return
+		default:
+		}
+		s.forwarding = true
+		go s.forward()
+	}
+	s.backlog = append(s.backlog, c)
+}
+
+// forward sends the queued completions to C until the backlog is empty
+func (s *Stream) forward() {
+	for {
+		s.mu.Lock()
+		if len(s.backlog) == 0 {
+			s.forwarding = false
+			s.mu.Unlock()
+			return
+		}
+		c := s.backlog[0]
+		s.backlog[0] = Completion{}
+		s.backlog = s.backlog[1:]
+		s.mu.Unlock()
+
+		s.C <- c
+		if !c.More() {
+			close(s.C)
+			return
+		}
+	}
+}
+
+// Dispatcher owns the completion side of a Ring, it tags every request with a unique
+// UserData token and delivers each CQEvent to the Receiver of the request it belongs to
+//
+// Requests are submitted through a Submitter, so Submit is safe to call from multiple
+// goroutines, while a single background goroutine reaps the completions.
+type Dispatcher struct {
+	ring      *Ring
+	submitter *Submitter
+
+	next      atomic.Uint64
+	mu        sync.Mutex
+	receivers map[uint64]Receiver
+	closing   bool
+
+	closeOnce sync.Once
+	closeErr  error
+
+	buffers        *BufferTable
+	buffersRelease func(tag uint64, buf []byte)
+	filesRelease   func(tag uint64)
+
+	done chan struct{}
+	err  error
+}
+
+// NewDispatcher takes ownership of ring and starts reaping its completions
+//
+// Rings set up with SetupSingleIssuer or SetupDeferTaskRun can only be submitted to and
+// waited on from the thread that created them, so the dispatcher fails right away with
+// ErrSingleIssuer for them, and Close returns the error after closing the ring.
+func NewDispatcher(ring *Ring) *Dispatcher {
+	d := &Dispatcher{
+		ring:      ring,
+		submitter: NewSubmitter(ring, SubmitterQueueSize),
+		receivers: make(map[uint64]Receiver),
+		done:      make(chan struct{}),
+	}
+	if ring.Flags&uint32(SetupSingleIssuer|SetupDeferTaskRun) != 0 {
+		d.abort(ErrSingleIssuer)
+	}
+
+	go d.loop()
+
+	return d
+}
+
+// Ring returns the ring the dispatcher reaps completions from
+func (d *Dispatcher) Ring() *Ring {
+	return d.ring
+}
+
+// RouteBufferTags releases the tagged buffers of t as soon as the kernel posts their tag CQEs,
+// t must be registered on the dispatcher's ring
+//
+// The released function is called on the dispatcher's goroutine with the tag and the buffer
+// that was released, so it must not block, and may be nil if the buffers are not reused.
+func (d *Dispatcher) RouteBufferTags(t *BufferTable, released func(tag uint64, buf []byte)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.buffers, d.buffersRelease = t, released
+}
+
+// RouteFileTags calls released with the tag of every tagged FileTable slot of the dispatcher's
+// ring once the kernel no longer uses the file it held
+//
+// Like the released function of RouteBufferTags, released is called on the dispatcher's goroutine.
+func (d *Dispatcher) RouteFileTags(released func(tag uint64)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.filesRelease = released
+}
+
+// release hands the tag CQE with UserData tag to the table it belongs to
+func (d *Dispatcher) release(tag uint64) {
+	d.mu.Lock()
+	buffers, released, filesReleased := d.buffers, d.buffersRelease, d.filesRelease
+	d.mu.Unlock()
+
+	if tag&FileTagFlag != 0 {
+		if filesReleased != nil {
+			filesReleased(tag &^ (TagFlag | FileTagFlag))
+		}
+		return
+	}
+	if buffers == nil {
+		return
+	}
+	buf, ok := buffers.Release(tag)
+	if ok && released != nil {
+		released(tag&^TagFlag, buf)
+	}
+}
+
+// Submission reserves SQEs for requests that are submitted to the ring together
+type Submission struct {
+	d         *Dispatcher
+	queue     *SubmitQueue
+	tokens    []uint64
+	receivers []Receiver
+}
+
+// Entry reserves an SQE for a new request whose completions are delivered to r, a nil r
+// discards them, and the UserData of the SQE holds the request's token and must not be changed
+func (s *Submission) Entry(r Receiver) (*SQEntry, error) {
+	sqe, err := s.queue.GetSQEntry()
+	if err != nil {
+		return nil, err
+	}
+
+	token := s.d.next.Add(1)
+	if token == LIBURING_UDATA_TIMEOUT {
+		token = s.d.next.Add(1)
+	}
+
+	if f, ok := r.(*Future); ok {
+		f.d = s.d
+		f.token = token
+	}
+
+	sqe.UserData = token
+	s.tokens = append(s.tokens, token)
+	s.receivers = append(s.receivers, r)
+
+	return sqe, nil
+}
+
+// Submit calls prepare to reserve and prepare SQEs through a Submission, and blocks until
+// they have been submitted together with the requests of other goroutines
+//
+// If prepare returns an error, none of the SQEs it reserved are submitted. Like the prepare
+// functions passed to Submitter.Submit, it may be called more than once.
+func (d *Dispatcher) Submit(prepare func(s *Submission) error) error {
+	return d.submit(prepare, false)
+}
+
+// Post is like Submit, but queues prepare without waiting for its SQEs to be submitted, so it
+// can be called from a Receiver, failed is called with the error Submit would have returned
+// and may be nil
+//
+// Requests that are posted may be submitted after requests that are submitted later.
+func (d *Dispatcher) Post(prepare func(s *Submission) error, failed func(err error)) {
+	go func() {
+		err := d.Submit(prepare)
+		if err != nil && failed != nil {
+			failed(err)
+		}
+	}()
+}
+
+// submit is Submit, closing allows requests to be submitted while the dispatcher is closing
+func (d *Dispatcher) submit(prepare func(s *Submission) error, closing bool) error {
+	var tokens []uint64
+	err := d.submitter.Submit(func(q *SubmitQueue) error {
+		s := Submission{d: d, queue: q}
+		err := prepare(&s)
+		if err != nil {
+			return err
+		}
+
+		// Registering the receivers after the SQEs have been prepared also publishes
+		// everything prepare wrote to the goroutine that delivers the completions
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.err != nil {
+			return d.err
+		}
+		if d.closing && !closing {
+			return ErrDispatcherClosed
+		}
+		for i, token := range s.tokens {
+			d.receivers[token] = s.receivers[i]
+		}
+		tokens = s.tokens
+
+		return nil
+	})
+	if errors.Is(err, ErrSubmitterClosed) {
+		return ErrDispatcherClosed
+	}
+	if err != nil && len(tokens) > 0 {
+		d.forget(tokens)
+	}
+
+	return err
+}
+
+// Do submits a single request prepared by prepare and waits for its completion, with the
+// same cancellation semantics as Future.Wait
+func (d *Dispatcher) Do(ctx context.Context, prepare func(sqe *SQEntry)) (Completion, error) {
+	err := ctx.Err()
+	if err != nil {
+		return Completion{}, err
+	}
+
+	f := NewFuture()
+	err = d.Submit(func(s *Submission) error {
+		sqe, err := s.Entry(f)
+		if err != nil {
+			return err
+		}
+		prepare(sqe)
+		return nil
+	})
+	if err != nil {
+		return Completion{}, err
+	}
+
+	return f.Wait(ctx)
+}
+
+// Cancel submits a cancel request for the request tagged with token, the returned Future
+// completes with ENOENT if the request is no longer in flight
+func (d *Dispatcher) Cancel(token uint64) (*Future, error) {
+	f := NewFuture()
+	err := d.Submit(func(s *Submission) error {
+		sqe, err := s.Entry(f)
+		if err != nil {
+			return err
+		}
+		sqe.PrepareCancel(token, 0)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Close stops accepting new requests, cancels every request that is still in flight,
+// waits for all of them to complete, and then closes the ring
+//
+// Requests can only be cancelled all at once on kernels that support IORING_ASYNC_CANCEL_ANY
+// (5.19 and later), on older kernels Close waits for in-flight requests to complete on their own.
+// The ring is only closed by the first call, later calls return the error the dispatcher failed
+// with, or ErrDispatcherClosed if it did not fail.
+func (d *Dispatcher) Close() error {
+	closed := false
+	d.closeOnce.Do(func() {
+		closed = true
+		d.closeErr = d.close()
+	})
+	if closed {
+		return d.closeErr
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return d.err
+	}
+	return ErrDispatcherClosed
+}
+
+// close is Close, it is only called once
+func (d *Dispatcher) close() error {
+	d.mu.Lock()
+	d.closing = true
+	d.mu.Unlock()
+
+	err := d.submit(func(s *Submission) error {
+		sqe, err := s.Entry(nil)
+		if err != nil {
+			return err
+		}
+		sqe.PrepareCancel(0, uint32(AsyncCancelFlagAny))
+		return nil
+	}, true)
+	_ = d.submitter.Close()
+	if err != nil {
+		// A dispatcher that has failed stops reaping on its own, so only a dispatcher
+		// that is still running is left to requests that could not be cancelled
+		d.mu.Lock()
+		failed := d.err != nil
+		d.mu.Unlock()
+		if !failed {
+			select {
+			case <-d.done:
+			default:
+				return fmt.Errorf("error while cancelling requests on ring with fd %d: %w", d.ring.FD, err)
+			}
+		}
+	}
+
+	<-d.done
+
+	err = d.ring.Close()
+	if d.err != nil {
+		return d.err
+	}
+	if err != nil {
+		return fmt.Errorf("error while closing ring with fd %d: %w", d.ring.FD, err)
+	}
+
+	return nil
+}
+
+// forget removes the receivers of requests that were never submitted
+func (d *Dispatcher) forget(tokens []uint64) {
+	d.mu.Lock()
+	for _, token := range tokens {
+		delete(d.receivers, token)
+	}
+	d.mu.Unlock()
+}
+
+// loop reaps completions and delivers them to their receivers until the dispatcher
+// has been closed and no requests are left in flight
+//
+// Completions are reaped in batches of up to dispatcherBatchSize, and every batch is
+// consumed from the CQ before it is delivered, so receivers that block or submit new
+// requests do not hold up the kernel.
+func (d *Dispatcher) loop() {
+	defer close(d.done)
+
+	cqes := make([]*CQEvent, dispatcherBatchSize)
+	var tokens [dispatcherBatchSize]uint64
+	var completions [dispatcherBatchSize]Completion
+	var receivers [dispatcherBatchSize]Receiver
+	for {
+		d.mu.Lock()
+		finished := d.closing && len(d.receivers) == 0
+		d.mu.Unlock()
+		if finished {
+			return
+		}
+
+		_, err := d.ring.WaitCQEvent()
+		if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ETIME) {
+			continue
+		}
+		if err != nil {
+			d.abort(fmt.Errorf("error while waiting for CQE on ring with fd %d: %w", d.ring.FD, err))
+			return
+		}
+
+		n := d.ring.PeekBatchCQE(cqes)
+		for i, cqe := range cqes[:n] {
+			tokens[i], completions[i] = cqe.UserData, Completion{Res: cqe.Res, Flags: cqe.Flags}
+		}
+		d.ring.CQAdvance(uint32(n))
+		d.submitter.Reaped()
+
+		d.mu.Lock()
+		for i, token := range tokens[:n] {
+			if token&TagFlag != 0 {
+				continue
+			}
+			r, ok := d.receivers[token]
+			if ok && !completions[i].More() {
+				delete(d.receivers, token)
+			}
+			receivers[i] = r
+		}
+		d.mu.Unlock()
+
+		for i, r := range receivers[:n] {
+			if tokens[i]&TagFlag != 0 {
+				d.release(tokens[i])
+				continue
+			}
+			if r != nil {
+				r.Deliver(completions[i])
+			}
+			receivers[i] = nil
+		}
+	}
+}
+
+// abort fails every request that is still in flight after the ring has stopped
+// delivering completions, so none of their receivers wait forever
+func (d *Dispatcher) abort(err error) {
+	d.mu.Lock()
+	d.err = err
+	receivers := d.receivers
+	d.receivers = make(map[uint64]Receiver)
+	d.closing = true
+	d.mu.Unlock()
+
+	for _, r := range receivers {
+		if r != nil {
+			r.Deliver(Completion{Res: -int32(syscall.EIO)})
+		}
+	}
+}
@@ -0,0 +1,345 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func newTestDispatcher(t *testing.T, entries uint32) *Dispatcher {
+	ring, err := NewRing()
+	require.NoError(t, err)
+	require.NoError(t, ring.QueueInit(entries, 0))
+
+	return NewDispatcher(ring)
+}
+
+func TestDispatcher(t *testing.T) {
+	d := newTestDispatcher(t, 64)
+
+	const goroutines = 16
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 64; j++ {
+				c, err := d.Do(context.Background(), func(sqe *SQEntry) {
+					sqe.PrepareNop()
+				})
+				require.NoError(t, err)
+				require.NoError(t, c.Err())
+			}
+		}()
+	}
+	wg.Wait()
+
+	completions := make(chan Completion, 2)
+	future := NewFuture()
+	err := d.Submit(func(s *Submission) error {
+		sqe, err := s.Entry(future)
+		if err != nil {
+			return err
+		}
+		sqe.PrepareNop()
+
+		sqe, err = s.Entry(ReceiverFunc(func(c Completion) {
+			completions <- c
+		}))
+		if err != nil {
+			return err
+		}
+		sqe.PrepareNop()
+		return nil
+	})
+	require.NoError(t, err)
+
+	<-future.Done()
+	require.Zero(t, future.Result().Res)
+	require.Zero(t, (<-completions).Res)
+
+	require.NoError(t, d.Close())
+	require.ErrorIs(t, d.Submit(func(*Submission) error { return nil }), ErrDispatcherClosed)
+}
+
+func TestDispatcherPost(t *testing.T) {
+	d := newTestDispatcher(t, 4)
+
+	// Every request is posted from the Receiver of the previous one
+	const requests = 256
+	done := make(chan struct{})
+	failed := func(err error) {
+		t.Errorf("unexpected post error: %v", err)
+	}
+	var r ReceiverFunc
+	var posted int
+	post := func() {
+		posted++
+		d.Post(func(s *Submission) error {
+			sqe, err := s.Entry(r)
+			if err != nil {
+				return err
+			}
+			sqe.PrepareNop()
+			return nil
+		}, failed)
+	}
+	r = func(c Completion) {
+		if posted == requests {
+			close(done)
+			return
+		}
+		post()
+	}
+	post()
+	<-done
+
+	require.NoError(t, d.Close())
+	errs := make(chan error, 1)
+	d.Post(func(*Submission) error { return nil }, func(err error) {
+		errs <- err
+	})
+	require.ErrorIs(t, <-errs, ErrDispatcherClosed)
+}
+
+func TestDispatcherCancel(t *testing.T) {
+	d := newTestDispatcher(t, 8)
+	t.Cleanup(func() {
+		require.NoError(t, d.Close())
+	})
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = syscall.Close(fds[0])
+		_ = syscall.Close(fds[1])
+	})
+
+	buf := make([]byte, 16)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	c, err := d.Do(ctx, func(sqe *SQEntry) {
+		sqe.PrepareRecv(fds[0], uintptr(unsafe.Pointer(&buf[0])), uint32(len(buf)), 0)
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.True(t, isCancelled(c.Res))
+
+	_, err = syscall.Write(fds[1], []byte("hello"))
+	require.NoError(t, err)
+
+	c, err = d.Do(context.Background(), func(sqe *SQEntry) {
+		sqe.PrepareRecv(fds[0], uintptr(unsafe.Pointer(&buf[0])), uint32(len(buf)), 0)
+	})
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:c.Res]))
+}
+
+func TestDispatcherBufferTags(t *testing.T) {
+	d := newTestDispatcher(t, 8)
+	t.Cleanup(func() {
+		require.NoError(t, d.Close())
+	})
+
+	table, err := d.Ring().RegisterBufferTable(2)
+	require.NoError(t, err)
+	released := make(chan []byte, 1)
+	d.RouteBufferTags(table, func(tag uint64, buf []byte) {
+		require.Equal(t, uint64(1), tag)
+		released <- buf
+	})
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = syscall.Close(fds[0])
+		_ = syscall.Close(fds[1])
+	})
+
+	// The first request gets token 1, which is also the tag of the buffer
+	buf := make([]byte, 16)
+	recv := NewFuture()
+	err = d.Submit(func(s *Submission) error {
+		sqe, err := s.Entry(recv)
+		if err != nil {
+			return err
+		}
+		require.Equal(t, uint64(1), sqe.UserData)
+		sqe.PrepareRecv(fds[0], uintptr(unsafe.Pointer(&buf[0])), uint32(len(buf)), 0)
+		return nil
+	})
+	require.NoError(t, err)
+
+	tagged := make([]byte, 64)
+	slot, err := table.Register(tagged, 1)
+	require.NoError(t, err)
+	require.NoError(t, table.Free(slot))
+	select {
+	case buf := <-released:
+		require.Equal(t, &tagged[0], &buf[0])
+	case <-time.After(time.Second):
+		t.Fatal("tagged buffer was not released")
+	}
+
+	// The tag CQE must not have been delivered to the request with the same token
+	select {
+	case <-recv.Done():
+		t.Fatal("tag CQE was delivered to a request")
+	default:
+	}
+	_, err = syscall.Write(fds[1], []byte("hello"))
+	require.NoError(t, err)
+	<-recv.Done()
+	require.Equal(t, "hello", string(buf[:recv.Result().Res]))
+
+	require.NoError(t, table.Unregister())
+}
+
+func TestDispatcherFileTags(t *testing.T) {
+	d := newTestDispatcher(t, 8)
+	t.Cleanup(func() {
+		require.NoError(t, d.Close())
+	})
+
+	table, err := d.Ring().RegisterFileTable(2)
+	require.NoError(t, err)
+	released := make(chan uint64, 1)
+	d.RouteFileTags(func(tag uint64) {
+		released <- tag
+	})
+
+	var fds [2]int
+	require.NoError(t, syscall.Pipe(fds[:]))
+	t.Cleanup(func() {
+		_ = syscall.Close(fds[0])
+		_ = syscall.Close(fds[1])
+	})
+
+	slot, err := table.Register(fds[0], 1)
+	require.NoError(t, err)
+	require.NoError(t, table.Free(slot))
+	select {
+	case tag := <-released:
+		require.Equal(t, uint64(1), tag)
+	case <-time.After(time.Second):
+		t.Fatal("tagged file was not released")
+	}
+
+	require.NoError(t, table.Unregister())
+	require.ErrorIs(t, table.Unregister(), ErrFileTableClosed)
+}
+
+func TestDispatcherSingleIssuer(t *testing.T) {
+	ring, err := NewRing()
+	require.NoError(t, err)
+	require.NoError(t, ring.QueueInitConfig(NewRingConfig(8, WithSingleIssuer())))
+
+	d := NewDispatcher(ring)
+	_, err = d.Do(context.Background(), func(sqe *SQEntry) {
+		sqe.PrepareNop()
+	})
+	require.ErrorIs(t, err, ErrSingleIssuer)
+	require.ErrorIs(t, d.Close(), ErrSingleIssuer)
+
+	// The ring fd is free again, so closing the dispatcher again must leave its new owner alone
+	var fds [2]int
+	require.NoError(t, syscall.Pipe(fds[:]))
+	t.Cleanup(func() {
+		_ = syscall.Close(fds[0])
+		_ = syscall.Close(fds[1])
+	})
+	require.ErrorIs(t, d.Close(), ErrSingleIssuer)
+	require.Equal(t, -1, ring.FD)
+	for _, fd := range fds {
+		var stat syscall.Stat_t
+		require.NoError(t, syscall.Fstat(fd, &stat))
+	}
+}
+
+func TestDispatcherStream(t *testing.T) {
+	d := newTestDispatcher(t, 8)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, l.Close())
+	})
+
+	file, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, file.Close())
+	})
+
+	stream := NewStream(4)
+	err = d.Submit(func(s *Submission) error {
+		sqe, err := s.Entry(stream)
+		if err != nil {
+			return err
+		}
+		sqe.PrepareMultishotAccept(int(file.Fd()), 0, 0, syscall.SOCK_CLOEXEC)
+		return nil
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		client, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+
+		c := <-stream.C
+		require.NoError(t, c.Err())
+		require.True(t, c.More())
+		require.NoError(t, syscall.Close(int(c.Res)))
+		require.NoError(t, client.Close())
+	}
+
+	require.NoError(t, d.Close())
+
+	c, ok := <-stream.C
+	require.True(t, ok)
+	require.False(t, c.More())
+	require.True(t, isCancelled(c.Res))
+
+	_, ok = <-stream.C
+	require.False(t, ok)
+}
+
+func TestStreamBacklog(t *testing.T) {
+	stream := NewStream(1)
+
+	const completions = 16
+	for i := 0; i < completions; i++ {
+		flags := uint32(CQEventFlagMore)
+		if i == completions-1 {
+			flags = 0
+		}
+		stream.Deliver(Completion{Res: int32(i), Flags: flags})
+	}
+
+	var res int32
+	for c := range stream.C {
+		require.Equal(t, res, c.Res)
+		res++
+	}
+	require.Equal(t, int32(completions), res)
+}
@@ -16,6 +16,36 @@
 
 package iouring
 
+import (
+	"unsafe"
+)
+
+// SQECommandSize is the size of the command area of SQEs on rings set up with SetupSQE128
+const SQECommandSize = 80
+
+// Command returns the command area of an SQE, which overlaps UnionAddress3 and extends
+// into the second half of SQEs on rings set up with SetupSQE128, it must not be used for
+// SQEs of other rings
+func (e *SQEntry) Command() *[SQECommandSize]byte {
+	return (*[SQECommandSize]byte)(unsafe.Pointer(&e.UnionAddress3))
+}
+
+// SetFixedFile makes the SQE refer to slot of the ring's FileTable instead of a file descriptor,
+// it must be called after the SQE has been prepared
+func (e *SQEntry) SetFixedFile(slot uint32) {
+	e.FD = int32(slot)
+	e.Flags |= uint8(SQEntryFlagFixedFile)
+}
+
+// SetBufferSelect makes the request pick its buffer from the provided buffers of group, the
+// request is prepared with a nil buffer and the length is the most it reads into the buffer
+//
+// The ID of the buffer that was picked is returned by CQEvent.BufferID.
+func (e *SQEntry) SetBufferSelect(group uint16) {
+	e.Flags |= uint8(SQEntryFlagBufferSelect)
+	e.UnionBufferIndexPacked = group
+}
+
 // PrepareRW is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L378
 func (e *SQEntry) PrepareRW(opCode OpCode, fd int, addressPointer uintptr, length uint32, offset uint64) {
 	e.OpCode = uint8(opCode)
@@ -33,8 +63,192 @@ func (e *SQEntry) PrepareRW(opCode OpCode, fd int, addressPointer uintptr, lengt
 	e.UnionAddress3._Pad2[0] = 0
 }
 
+// PrepareNop is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (e *SQEntry) PrepareNop() {
+	e.PrepareRW(OpCodeNOP, -1, 0, 0, 0)
+}
+
 // PrepareAccept is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L591
 func (e *SQEntry) PrepareAccept(fd int, addressPointer uintptr, addressLength uint64, flags uint32) {
 	e.PrepareRW(OpCodeAccept, fd, addressPointer, 0, addressLength)
 	e.UnionRWFlags = flags
 }
+
+// PrepareMultishotAccept is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (e *SQEntry) PrepareMultishotAccept(fd int, addressPointer uintptr, addressLength uint64, flags uint32) {
+	e.PrepareAccept(fd, addressPointer, addressLength, flags)
+	e.IOPriority |= uint16(AcceptFlagMultishot)
+}
+
+// setTargetFixedFile is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L371
+func (e *SQEntry) setTargetFixedFile(fileIndex uint32) {
+	// 0 means no fixed file, so the kernel expects the index to be offset by one
+	e.UnionSplicedFDIn = int32(fileIndex + 1)
+}
+
+// PrepareAcceptDirect is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L608
+//
+// The accepted socket is installed into slot fileIndex of the ring's FileTable instead of the
+// process fd table, or into a free slot of the allocation range if fileIndex is FileIndexAlloc,
+// and flags must not contain SOCK_CLOEXEC.
+func (e *SQEntry) PrepareAcceptDirect(fd int, addressPointer uintptr, addressLength uint64, flags uint32, fileIndex uint32) {
+	e.PrepareAccept(fd, addressPointer, addressLength, flags)
+	if fileIndex == FileIndexAlloc {
+		fileIndex--
+	}
+	e.setTargetFixedFile(fileIndex)
+}
+
+// PrepareMultishotAcceptDirect is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L631
+func (e *SQEntry) PrepareMultishotAcceptDirect(fd int, addressPointer uintptr, addressLength uint64, flags uint32) {
+	e.PrepareMultishotAccept(fd, addressPointer, addressLength, flags)
+	e.setTargetFixedFile(FileIndexAlloc - 1)
+}
+
+// PrepareOpenat is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L743
+func (e *SQEntry) PrepareOpenat(dfd int, path *byte, flags uint32, mode uint32) {
+	e.PrepareRW(OpCodeOpenat, dfd, uintptr(unsafe.Pointer(path)), mode, 0)
+	e.UnionRWFlags = flags
+}
+
+// PrepareOpenatDirect is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L751
+//
+// The file is opened into slot fileIndex of the ring's FileTable, or into a free slot of
+// the allocation range if fileIndex is FileIndexAlloc, and flags must not contain O_CLOEXEC.
+func (e *SQEntry) PrepareOpenatDirect(dfd int, path *byte, flags uint32, mode uint32, fileIndex uint32) {
+	e.PrepareOpenat(dfd, path, flags, mode)
+	if fileIndex == FileIndexAlloc {
+		fileIndex--
+	}
+	e.setTargetFixedFile(fileIndex)
+}
+
+// PrepareSocket is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L1081
+func (e *SQEntry) PrepareSocket(domain int, socketType int, protocol int, flags uint32) {
+	e.PrepareRW(OpCodeSocket, domain, 0, uint32(protocol), uint64(socketType))
+	e.UnionRWFlags = flags
+}
+
+// PrepareSocketDirect is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L1090
+//
+// The socket is created in slot fileIndex of the ring's FileTable, or in a free slot of
+// the allocation range if fileIndex is FileIndexAlloc.
+func (e *SQEntry) PrepareSocketDirect(domain int, socketType int, protocol int, flags uint32, fileIndex uint32) {
+	e.PrepareSocket(domain, socketType, protocol, flags)
+	if fileIndex == FileIndexAlloc {
+		fileIndex--
+	}
+	e.setTargetFixedFile(fileIndex)
+}
+
+// PrepareCloseDirect is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L735
+func (e *SQEntry) PrepareCloseDirect(fileIndex uint32) {
+	e.PrepareClose(0)
+	e.setTargetFixedFile(fileIndex)
+}
+
+// PrepareFixedFDInstall is defined here: https://github.com/axboe/liburing/blob/liburing-2.6/src/include/liburing.h#L1223
+//
+// It installs the file in slot fileIndex of the ring's FileTable into the process fd table,
+// and completes with the new fd, which is close-on-exec unless FixedFDInstallFlagNoCloexec is set.
+func (e *SQEntry) PrepareFixedFDInstall(fileIndex uint32, flags uint32) {
+	e.PrepareRW(OpCodeFixedFDInstall, int(fileIndex), 0, 0, 0)
+	e.Flags = uint8(SQEntryFlagFixedFile)
+	e.UnionRWFlags = flags
+}
+
+// PrepareCancel is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (e *SQEntry) PrepareCancel(userData uint64, flags uint32) {
+	e.PrepareRW(OpCodeAsyncCancel, -1, 0, 0, 0)
+	e.UnionAddress = userData
+	e.UnionRWFlags = flags
+}
+
+// PrepareTimeout is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (e *SQEntry) PrepareTimeout(ts *KernelTimespec, count uint32, flags uint32) {
+	e.PrepareRW(OpCodeTimeout, -1, uintptr(unsafe.Pointer(ts)), 1, uint64(count))
+	e.UnionRWFlags = flags
+}
+
+// PrepareTimeoutRemove is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (e *SQEntry) PrepareTimeoutRemove(userData uint64, flags uint32) {
+	e.PrepareRW(OpCodeTimeoutRemove, -1, 0, 0, 0)
+	e.UnionAddress = userData
+	e.UnionRWFlags = flags
+}
+
+// PrepareLinkTimeout is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (e *SQEntry) PrepareLinkTimeout(ts *KernelTimespec, flags uint32) {
+	e.PrepareRW(OpCodeLinkTimeout, -1, uintptr(unsafe.Pointer(ts)), 1, 0)
+	e.UnionRWFlags = flags
+}
+
+// PrepareConnect is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (e *SQEntry) PrepareConnect(fd int, addressPointer uintptr, addressLength uint32) {
+	e.PrepareRW(OpCodeConnect, fd, addressPointer, 0, uint64(addressLength))
+}
+
+// PrepareReadFixed is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L478
+//
+// The buffer must lie within the registered buffer at bufferIndex.
+func (e *SQEntry) PrepareReadFixed(fd int, bufferPointer uintptr, length uint32, offset uint64, bufferIndex uint16) {
+	e.PrepareRW(OpCodeReadFixed, fd, bufferPointer, length, offset)
+	e.UnionBufferIndexPacked = bufferIndex
+}
+
+// PrepareWriteFixed is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L515
+//
+// The buffer must lie within the registered buffer at bufferIndex.
+func (e *SQEntry) PrepareWriteFixed(fd int, bufferPointer uintptr, length uint32, offset uint64, bufferIndex uint16) {
+	e.PrepareRW(OpCodeWriteFixed, fd, bufferPointer, length, offset)
+	e.UnionBufferIndexPacked = bufferIndex
+}
+
+// PrepareProvideBuffers is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L947
+//
+// It hands number buffers of length bytes each, which lie back to back starting at addressPointer,
+// to buffer group bufferGroup with the buffer IDs starting at bufferID.
+func (e *SQEntry) PrepareProvideBuffers(addressPointer uintptr, length uint32, number int, bufferGroup uint16, bufferID uint16) {
+	e.PrepareRW(OpCodeProvideBuffers, number, addressPointer, length, uint64(bufferID))
+	e.UnionBufferIndexPacked = bufferGroup
+}
+
+// PrepareRemoveBuffers is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L955
+func (e *SQEntry) PrepareRemoveBuffers(number int, bufferGroup uint16) {
+	e.PrepareRW(OpCodeRemoveBuffers, number, 0, 0, 0)
+	e.UnionBufferIndexPacked = bufferGroup
+}
+
+// PrepareSend is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (e *SQEntry) PrepareSend(fd int, bufferPointer uintptr, length uint32, flags uint32) {
+	e.PrepareRW(OpCodeSend, fd, bufferPointer, length, 0)
+	e.UnionRWFlags = flags
+}
+
+// PrepareRecv is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (e *SQEntry) PrepareRecv(fd int, bufferPointer uintptr, length uint32, flags uint32) {
+	e.PrepareRW(OpCodeRecv, fd, bufferPointer, length, 0)
+	e.UnionRWFlags = flags
+}
+
+// PrepareSendMsg is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (e *SQEntry) PrepareSendMsg(fd int, msgPointer uintptr, flags uint32) {
+	e.PrepareRW(OpCodeSendMsg, fd, msgPointer, 1, 0)
+	e.UnionRWFlags = flags
+}
+
+// PrepareRecvMsg is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (e *SQEntry) PrepareRecvMsg(fd int, msgPointer uintptr, flags uint32) {
+	e.PrepareRW(OpCodeRecvMsg, fd, msgPointer, 1, 0)
+	e.UnionRWFlags = flags
+}
+
+// PrepareShutdown is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (e *SQEntry) PrepareShutdown(fd int, how int) {
+	e.PrepareRW(OpCodeShutdown, fd, 0, uint32(how), 0)
+}
+
+// PrepareClose is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (e *SQEntry) PrepareClose(fd int) {
+	e.PrepareRW(OpCodeClose, fd, 0, 0, 0)
+}
@@ -0,0 +1,245 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+var (
+	ErrFileTableFull    = errors.New("file table is full")
+	ErrFileTableClosed  = errors.New("file table is unregistered")
+	ErrInvalidFileSlot  = errors.New("invalid file table slot")
+	ErrFileTagsRequired = errors.New("file tags require IORING_REGISTER_FILES2 (Linux 5.13)")
+)
+
+// FileTable manages the registered (fixed) files of a Ring
+//
+// Requests that refer to a slot of the table instead of a file descriptor, by setting
+// IOSQE_FIXED_FILE with SQEntry.SetFixedFile, skip looking the file up on every request.
+// The table is registered sparse, so every slot starts out empty.
+//
+// Slots can be given a non-zero tag, and once a tagged slot has been replaced or freed,
+// and the kernel no longer uses the file it held, a CQE is posted with the tag, TagFlag and
+// FileTagFlag as its UserData and no other payload, which a Dispatcher hands to the function
+// set with RouteFileTags. Tags must not overlap TagFlag or FileTagFlag.
+type FileTable struct {
+	ring   *Ring
+	tagged bool
+
+	mu          sync.Mutex
+	used        []bool
+	next        uint32
+	allocOffset uint32
+	allocLength uint32
+	closed      bool
+}
+
+// RegisterFileTable registers a sparse file table with size slots on the ring, a ring
+// can only have one file table registered at a time
+//
+// Kernels without IORING_RSRC_REGISTER_SPARSE (before 5.19) fall back to registering
+// size empty slots, and kernels without IORING_REGISTER_FILES2 (before 5.13) do not
+// support tags.
+func (r *Ring) RegisterFileTable(size uint32) (*FileTable, error) {
+	if size == 0 {
+		return nil, fmt.Errorf("error while registering file table on ring with fd %d: %w", r.FD, ErrInvalidFileSlot)
+	}
+
+	tagged := true
+	_, err := r.RegisterFilesSparse(size)
+	if errors.Is(err, syscall.EINVAL) {
+		files := make([]int32, size)
+		for i := range files {
+			files[i] = -1
+		}
+		_, err = r.RegisterFilesTags(files, make([]uint64, size))
+		if errors.Is(err, syscall.EINVAL) {
+			tagged = false
+			_, err = r.RegisterFiles(files)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while registering file table with %d slots on ring with fd %d: %w", size, r.FD, err)
+	}
+
+	return &FileTable{
+		ring:   r,
+		tagged: tagged,
+		used:   make([]bool, size),
+	}, nil
+}
+
+// Size returns the number of slots in the table
+func (t *FileTable) Size() uint32 {
+	return uint32(len(t.used))
+}
+
+// Register installs fd into the first free slot of the table and returns the slot,
+// the kernel holds its own reference to the file so fd can be closed afterwards
+func (t *FileTable) Register(fd int, tag uint64) (uint32, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return 0, ErrFileTableClosed
+	}
+
+	size := uint32(len(t.used))
+	for i := uint32(0); i < size; i++ {
+		slot := (t.next + i) % size
+		if t.used[slot] || t.allocated(slot) {
+			continue
+		}
+
+		err := t.update(slot, []int32{int32(fd)}, []uint64{tag})
+		if err != nil {
+			return 0, err
+		}
+		t.used[slot] = true
+		t.next = slot + 1
+
+		return slot, nil
+	}
+
+	return 0, ErrFileTableFull
+}
+
+// SetAllocRange reserves length slots starting at offset for the kernel, which picks a free
+// slot from this range for direct accept, openat and socket requests prepared with FileIndexAlloc,
+// and Register only uses the slots outside of it
+//
+// Slots allocated by the kernel are not tracked by the table, they are emptied by closing
+// them with SQEntry.PrepareCloseDirect or with Free.
+func (t *FileTable) SetAllocRange(offset uint32, length uint32) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrFileTableClosed
+	}
+	if uint64(offset)+uint64(length) > uint64(len(t.used)) {
+		return ErrInvalidFileSlot
+	}
+
+	_, err := t.ring.RegisterFileAllocRange(offset, length)
+	if err != nil {
+		return fmt.Errorf("error while setting file allocation range of %d slots at offset %d on ring with fd %d: %w", length, offset, t.ring.FD, err)
+	}
+	t.allocOffset = offset
+	t.allocLength = length
+
+	return nil
+}
+
+// allocated reports whether slot is in the range reserved for the kernel, the caller must hold mu
+func (t *FileTable) allocated(slot uint32) bool {
+	return slot >= t.allocOffset && slot-t.allocOffset < t.allocLength
+}
+
+// Set installs fd into slot, replacing the file that was in it
+func (t *FileTable) Set(slot uint32, fd int, tag uint64) error {
+	return t.Update(slot, []int32{int32(fd)}, []uint64{tag})
+}
+
+// Free empties slot, the kernel drops its reference to the file once the requests
+// that are using it have completed
+func (t *FileTable) Free(slot uint32) error {
+	return t.Update(slot, []int32{-1}, nil)
+}
+
+// Update replaces the slots starting at offset with fds, where -1 empties a slot,
+// tags is either nil or holds a tag for every file
+func (t *FileTable) Update(offset uint32, fds []int32, tags []uint64) error {
+	if len(fds) == 0 {
+		return nil
+	}
+	if tags != nil && len(tags) != len(fds) {
+		return fmt.Errorf("error while updating file table: got %d tags for %d files", len(tags), len(fds))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrFileTableClosed
+	}
+	if uint64(offset)+uint64(len(fds)) > uint64(len(t.used)) {
+		return ErrInvalidFileSlot
+	}
+
+	err := t.update(offset, fds, tags)
+	if err != nil {
+		return err
+	}
+	for i, fd := range fds {
+		t.used[offset+uint32(i)] = fd != -1
+	}
+
+	return nil
+}
+
+// update replaces the slots starting at offset with fds, the caller must hold mu
+func (t *FileTable) update(offset uint32, fds []int32, tags []uint64) error {
+	var tagged bool
+	kernelTags := make([]uint64, len(tags))
+	for i, tag := range tags {
+		if tag&(TagFlag|FileTagFlag) != 0 {
+			return ErrInvalidTag
+		}
+		if tag != 0 {
+			tagged = true
+			kernelTags[i] = tag | TagFlag | FileTagFlag
+		}
+	}
+
+	var n uint
+	var err error
+	switch {
+	case tagged && !t.tagged:
+		return ErrFileTagsRequired
+	case tagged:
+		n, err = t.ring.RegisterFilesUpdateTag(offset, fds, kernelTags)
+	default:
+		n, err = t.ring.RegisterFilesUpdate(offset, fds)
+	}
+	if err == nil && n != uint(len(fds)) {
+		err = syscall.EBADF
+	}
+	if err != nil {
+		return fmt.Errorf("error while updating %d file table slots at offset %d on ring with fd %d: %w", len(fds), offset, t.ring.FD, err)
+	}
+
+	return nil
+}
+
+// Unregister unregisters the table, which frees every slot that is still in use
+func (t *FileTable) Unregister() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrFileTableClosed
+	}
+
+	_, err := t.ring.UnregisterFiles()
+	if err != nil {
+		return fmt.Errorf("error while unregistering file table on ring with fd %d: %w", t.ring.FD, err)
+	}
+	t.closed = true
+
+	return nil
+}
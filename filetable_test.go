@@ -0,0 +1,86 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"github.com/stretchr/testify/require"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestFileTable(t *testing.T) {
+	ring := newTestRing(t, 8)
+
+	table, err := ring.RegisterFileTable(2)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), table.Size())
+
+	var fds [2]int
+	require.NoError(t, syscall.Pipe(fds[:]))
+	t.Cleanup(func() {
+		_ = syscall.Close(fds[1])
+	})
+
+	slot, err := table.Register(fds[0], 0)
+	require.NoError(t, err)
+	require.NoError(t, syscall.Close(fds[0]))
+
+	_, err = syscall.Write(fds[1], []byte("hello"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 5)
+	sqe := ring.GetSQEntry()
+	require.NotNil(t, sqe)
+	sqe.PrepareRW(OpCodeRead, 0, uintptr(unsafe.Pointer(&buf[0])), uint32(len(buf)), 0)
+	sqe.SetFixedFile(slot)
+	sqe.UserData = 1
+	_, err = ring.Submit()
+	require.NoError(t, err)
+
+	cqe, err := ring.WaitCQEventTimeout(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), cqe.UserData)
+	require.Equal(t, int32(len(buf)), cqe.Res)
+	require.Equal(t, "hello", string(buf))
+	ring.CQESeen(cqe)
+
+	_, err = table.Register(fds[1], 0)
+	require.NoError(t, err)
+	_, err = table.Register(fds[1], 0)
+	require.ErrorIs(t, err, ErrFileTableFull)
+	require.ErrorIs(t, table.Set(2, fds[1], 0), ErrInvalidFileSlot)
+	require.ErrorIs(t, table.Set(1, fds[1], FileTagFlag), ErrInvalidTag)
+
+	// Replacing a tagged slot posts a CQE with its tag once the file is no longer used
+	require.NoError(t, table.Set(slot, fds[1], 42))
+	require.NoError(t, table.Free(slot))
+	cqe, err = ring.WaitCQEventTimeout(time.Second)
+	require.NoError(t, err)
+	require.Equal(t, 42|TagFlag|FileTagFlag, cqe.UserData)
+	ring.CQESeen(cqe)
+
+	slot, err = table.Register(fds[1], 0)
+	require.NoError(t, err)
+	require.Zero(t, slot)
+
+	require.NoError(t, table.Unregister())
+	require.ErrorIs(t, table.Unregister(), ErrFileTableClosed)
+	_, err = table.Register(fds[1], 0)
+	require.ErrorIs(t, err, ErrFileTableClosed)
+}
@@ -0,0 +1,120 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"fmt"
+	"github.com/loopholelabs/iouring/pkg/buffer"
+	"math"
+	"unsafe"
+)
+
+// FixedBuffer is a buffer.Fixed that is registered in the BufferTable of a Ring, which
+// read and write fixed requests refer to by its index
+type FixedBuffer struct {
+	*buffer.Fixed
+	index uint16
+}
+
+// Index returns the slot of the buffer in the BufferTable it is registered with
+func (b *FixedBuffer) Index() uint16 {
+	return b.index
+}
+
+// Extend adds n bytes that a read fixed request wrote past the end of the buffer to its length
+func (b *FixedBuffer) Extend(n int) {
+	*b.Fixed = (*b.Fixed)[:len(*b.Fixed)+n]
+}
+
+// RegisterFixed registers the whole capacity of every buffer in bufs in a free slot of the table,
+// and returns handles that know the slot they were registered in
+func (t *BufferTable) RegisterFixed(bufs ...*buffer.Fixed) ([]*FixedBuffer, error) {
+	if t.Size() > math.MaxUint16+1 {
+		return nil, fmt.Errorf("error while registering fixed buffers: buffer table with %d slots exceeds the buffer index range", t.Size())
+	}
+
+	fixed := make([]*FixedBuffer, 0, len(bufs))
+	for _, buf := range bufs {
+		slot, err := t.Register((*buf)[:cap(*buf)], 0)
+		if err != nil {
+			_ = t.UnregisterFixed(fixed...)
+			return nil, fmt.Errorf("error while registering fixed buffer %d of %d: %w", len(fixed), len(bufs), err)
+		}
+		fixed = append(fixed, &FixedBuffer{Fixed: buf, index: uint16(slot)})
+	}
+
+	return fixed, nil
+}
+
+// RegisterFixedPool takes n buffers from pool and registers them like RegisterFixed,
+// the buffers are returned to the pool if they cannot be registered
+func (t *BufferTable) RegisterFixedPool(pool *buffer.FixedPool, n int) ([]*FixedBuffer, error) {
+	bufs := make([]*buffer.Fixed, 0, n)
+	for i := 0; i < n; i++ {
+		buf, err := pool.Get()
+		if err != nil {
+			for _, buf := range bufs {
+				pool.Put(buf)
+			}
+			return nil, fmt.Errorf("error while getting fixed buffer %d of %d from pool: %w", i, n, err)
+		}
+		bufs = append(bufs, buf)
+	}
+
+	fixed, err := t.RegisterFixed(bufs...)
+	if err != nil {
+		for _, buf := range bufs {
+			pool.Put(buf)
+		}
+		return nil, err
+	}
+
+	return fixed, nil
+}
+
+// UnregisterFixed frees the slots of bufs, the buffers themselves are left open and can be
+// closed or returned to their pool once the requests that are using them have completed
+func (t *BufferTable) UnregisterFixed(bufs ...*FixedBuffer) error {
+	for _, buf := range bufs {
+		err := t.Free(uint32(buf.index))
+		if err != nil {
+			return fmt.Errorf("error while unregistering fixed buffer at index %d: %w", buf.index, err)
+		}
+	}
+
+	return nil
+}
+
+// PrepareReadFixedBuffer prepares a read fixed request that reads into the free capacity of buf,
+// the number of bytes that were read has to be added to its length with Extend
+func (e *SQEntry) PrepareReadFixedBuffer(fd int, buf *FixedBuffer, offset uint64) {
+	free := (*buf.Fixed)[len(*buf.Fixed):cap(*buf.Fixed)]
+	var pointer uintptr
+	if len(free) > 0 {
+		pointer = uintptr(unsafe.Pointer(&free[0]))
+	}
+	e.PrepareReadFixed(fd, pointer, uint32(len(free)), offset, buf.index)
+}
+
+// PrepareWriteFixedBuffer prepares a write fixed request that writes the contents of buf
+func (e *SQEntry) PrepareWriteFixedBuffer(fd int, buf *FixedBuffer, offset uint64) {
+	var pointer uintptr
+	if len(*buf.Fixed) > 0 {
+		pointer = uintptr(unsafe.Pointer(&(*buf.Fixed)[0]))
+	}
+	e.PrepareWriteFixed(fd, pointer, uint32(len(*buf.Fixed)), offset, buf.index)
+}
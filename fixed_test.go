@@ -0,0 +1,84 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"github.com/loopholelabs/iouring/pkg/buffer"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFixedBuffer(t *testing.T) {
+	ring := newTestRing(t, 8)
+
+	table, err := ring.RegisterBufferTable(4)
+	require.NoError(t, err)
+
+	pool := buffer.NewFixedPool(4096)
+	bufs, err := table.RegisterFixedPool(pool, 2)
+	require.NoError(t, err)
+	require.Len(t, bufs, 2)
+	require.Equal(t, uint16(0), bufs[0].Index())
+	require.Equal(t, uint16(1), bufs[1].Index())
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "fixed"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = f.Close()
+	})
+
+	complete := func() int32 {
+		_, err := ring.Submit()
+		require.NoError(t, err)
+		cqe, err := ring.WaitCQEventTimeout(time.Second)
+		require.NoError(t, err)
+		ring.CQESeen(cqe)
+		return cqe.Res
+	}
+
+	_, err = bufs[0].Write([]byte("hello fixed"))
+	require.NoError(t, err)
+	sqe := ring.GetSQEntry()
+	sqe.PrepareWriteFixedBuffer(int(f.Fd()), bufs[0], 0)
+	require.Equal(t, int32(bufs[0].Len()), complete())
+
+	sqe = ring.GetSQEntry()
+	sqe.PrepareReadFixedBuffer(int(f.Fd()), bufs[1], 6)
+	res := complete()
+	require.Equal(t, int32(5), res)
+	bufs[1].Extend(int(res))
+	require.Equal(t, "fixed", string(bufs[1].Bytes()))
+
+	// A buffer that is no longer registered cannot be used by fixed requests
+	require.NoError(t, table.UnregisterFixed(bufs[1]))
+	require.Nil(t, table.Buffer(uint32(bufs[1].Index())))
+	sqe = ring.GetSQEntry()
+	sqe.PrepareReadFixedBuffer(int(f.Fd()), bufs[1], 0)
+	require.Negative(t, complete())
+
+	buf, err := buffer.NewFixed(4096)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = buf.Close()
+	})
+	fixed, err := table.RegisterFixed(buf)
+	require.NoError(t, err)
+	require.Equal(t, uint16(2), fixed[0].Index())
+}
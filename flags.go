@@ -0,0 +1,186 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// KernelVersion is a Linux kernel release, such as 5.19
+type KernelVersion struct {
+	Major int
+	Minor int
+}
+
+// ParseKernelVersion parses the major and minor version from a kernel release such as "6.1.0-13-amd64"
+func ParseKernelVersion(release string) (KernelVersion, error) {
+	fields := strings.FieldsFunc(release, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	if len(fields) < 2 {
+		return KernelVersion{}, fmt.Errorf("error while parsing kernel release %q: missing minor version", release)
+	}
+
+	major, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return KernelVersion{}, fmt.Errorf("error while parsing major version of kernel release %q: %w", release, err)
+	}
+	minor, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return KernelVersion{}, fmt.Errorf("error while parsing minor version of kernel release %q: %w", release, err)
+	}
+
+	return KernelVersion{Major: major, Minor: minor}, nil
+}
+
+// AtLeast reports whether v is the same release as other or a later one
+func (v KernelVersion) AtLeast(other KernelVersion) bool {
+	return v.Major > other.Major || (v.Major == other.Major && v.Minor >= other.Minor)
+}
+
+func (v KernelVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// flagInfo is the name of a flag, as it is spelled in io_uring.h without its prefix,
+// and the kernel release that introduced it
+type flagInfo struct {
+	name   string
+	kernel KernelVersion
+}
+
+var (
+	featureInfo = [...]flagInfo{
+		{"single_mmap", KernelVersion{5, 4}},
+		{"nodrop", KernelVersion{5, 5}},
+		{"submit_stable", KernelVersion{5, 5}},
+		{"rw_cur_pos", KernelVersion{5, 6}},
+		{"cur_personality", KernelVersion{5, 6}},
+		{"fast_poll", KernelVersion{5, 7}},
+		{"poll_32bits", KernelVersion{5, 9}},
+		{"sqpoll_nonfixed", KernelVersion{5, 11}},
+		{"ext_arg", KernelVersion{5, 11}},
+		{"native_workers", KernelVersion{5, 12}},
+		{"rsrc_tags", KernelVersion{5, 13}},
+		{"cqe_skip", KernelVersion{5, 17}},
+		{"linked_file", KernelVersion{5, 17}},
+		{"reg_reg_ring", KernelVersion{6, 3}},
+	}
+
+	setupInfo = [...]flagInfo{
+		{"iopoll", KernelVersion{5, 1}},
+		{"sqpoll", KernelVersion{5, 1}},
+		{"sq_aff", KernelVersion{5, 1}},
+		{"cqsize", KernelVersion{5, 5}},
+		{"clamp", KernelVersion{5, 6}},
+		{"attach_wq", KernelVersion{5, 6}},
+		{"r_disabled", KernelVersion{5, 10}},
+		{"submit_all", KernelVersion{5, 18}},
+		{"coop_taskrun", KernelVersion{5, 19}},
+		{"taskrun_flag", KernelVersion{5, 19}},
+		{"sqe128", KernelVersion{5, 19}},
+		{"cqe32", KernelVersion{5, 19}},
+		{"single_issuer", KernelVersion{6, 0}},
+		{"defer_taskrun", KernelVersion{6, 1}},
+		{"no_mmap", KernelVersion{6, 5}},
+		{"registered_fd_only", KernelVersion{6, 5}},
+	}
+
+	enterInfo = [...]flagInfo{
+		{"getevents", KernelVersion{5, 1}},
+		{"sq_wakeup", KernelVersion{5, 1}},
+		{"sq_wait", KernelVersion{5, 10}},
+		{"ext_arg", KernelVersion{5, 11}},
+		{"registered_ring", KernelVersion{5, 18}},
+	}
+)
+
+// flagString joins the names of the flags set in flags with commas, unknown flags are printed in hex
+func flagString(flags uint32, info []flagInfo) string {
+	var names []string
+	for flags != 0 {
+		bit := bits.TrailingZeros32(flags)
+		if bit >= len(info) {
+			names = append(names, fmt.Sprintf("%#x", flags))
+			break
+		}
+		names = append(names, info[bit].name)
+		flags &^= 1 << bit
+	}
+	return strings.Join(names, ",")
+}
+
+// flagMinKernel returns the first kernel release that supports all the flags set in flags
+func flagMinKernel(flags uint32, info []flagInfo) KernelVersion {
+	var kernel KernelVersion
+	for flags != 0 {
+		bit := bits.TrailingZeros32(flags)
+		if bit < len(info) && !kernel.AtLeast(info[bit].kernel) {
+			kernel = info[bit].kernel
+		}
+		flags &^= 1 << bit
+	}
+	return kernel
+}
+
+// Has reports whether all the features in other are set in f
+func (f Feature) Has(other Feature) bool {
+	return f&other == other
+}
+
+// MinKernel returns the first kernel release that reports all the features in f
+func (f Feature) MinKernel() KernelVersion {
+	return flagMinKernel(uint32(f), featureInfo[:])
+}
+
+// String returns the names of the features in f, such as "nodrop,fast_poll,ext_arg"
+func (f Feature) String() string {
+	return flagString(uint32(f), featureInfo[:])
+}
+
+// Has reports whether all the flags in other are set in s
+func (s Setup) Has(other Setup) bool {
+	return s&other == other
+}
+
+// MinKernel returns the first kernel release that accepts all the flags in s
+func (s Setup) MinKernel() KernelVersion {
+	return flagMinKernel(uint32(s), setupInfo[:])
+}
+
+// String returns the names of the flags in s, such as "sqpoll,sq_aff"
+func (s Setup) String() string {
+	return flagString(uint32(s), setupInfo[:])
+}
+
+// Has reports whether all the flags in other are set in e
+func (e Enter) Has(other Enter) bool {
+	return e&other == other
+}
+
+// MinKernel returns the first kernel release that accepts all the flags in e
+func (e Enter) MinKernel() KernelVersion {
+	return flagMinKernel(uint32(e), enterInfo[:])
+}
+
+// String returns the names of the flags in e, such as "getevents,sq_wakeup"
+func (e Enter) String() string {
+	return flagString(uint32(e), enterInfo[:])
+}
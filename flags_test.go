@@ -0,0 +1,60 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFlags(t *testing.T) {
+	features := FeatureNoDrop | FeatureFastPoll | FeatureExtArg | FeatureRegRegRing
+	require.Equal(t, "nodrop,fast_poll,ext_arg,reg_reg_ring", features.String())
+	require.True(t, features.Has(FeatureNoDrop|FeatureExtArg))
+	require.False(t, features.Has(FeatureNoDrop|FeatureSingleMMap))
+	require.Equal(t, KernelVersion{Major: 6, Minor: 3}, features.MinKernel())
+	require.Equal(t, "0x40000000", Feature(1<<30).String())
+	require.Empty(t, Feature(0).String())
+
+	setup := SetupSingleIssuer | SetupDeferTaskRun
+	require.Equal(t, "single_issuer,defer_taskrun", setup.String())
+	require.Equal(t, "6.1", setup.MinKernel().String())
+
+	enter := EnterGetEvents | EnterSQWakeup
+	require.Equal(t, "getevents,sq_wakeup", enter.String())
+	require.True(t, enter.Has(EnterSQWakeup))
+	require.Equal(t, KernelVersion{Major: 5, Minor: 1}, enter.MinKernel())
+
+	version, err := ParseKernelVersion("6.1.0-13-amd64")
+	require.NoError(t, err)
+	require.Equal(t, KernelVersion{Major: 6, Minor: 1}, version)
+	require.True(t, version.AtLeast(KernelVersion{Major: 5, Minor: 19}))
+	require.False(t, version.AtLeast(KernelVersion{Major: 6, Minor: 2}))
+	_, err = ParseKernelVersion("6")
+	require.Error(t, err)
+
+	ring, err := NewRing()
+	require.NoError(t, err)
+	require.NoError(t, ring.QueueInit(2, uint32(SetupClamp)))
+	t.Cleanup(func() {
+		_ = ring.Close()
+	})
+
+	require.True(t, ring.FeatureSet().Has(FeatureSingleMMap|FeatureNoDrop))
+	require.Contains(t, ring.FeatureSet().String(), "nodrop")
+	require.Equal(t, SetupClamp, ring.SetupFlags())
+}
@@ -18,17 +18,123 @@
 
 package iouring
 
-import "syscall"
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
 
 var (
-	_available = false
+	availabilityOnce sync.Once
+	availability     AvailabilityReport
+
+	// procSysKernel and procSelfStatus are where the io_uring sysctls and the seccomp
+	// mode of the process are read from, tests point them at fixtures
+	procSysKernel  = "/proc/sys/kernel"
+	procSelfStatus = "/proc/self/status"
 )
 
-func init() {
-	_, _, errno := syscall.RawSyscall(SYS_IO_URING_REGISTER, 0, 1, 0)
-	_available = errno == syscall.ENOSYS
+// IsAvailable reports whether io_uring can be used, by setting up and tearing down a tiny ring
+//
+// The check only runs once, and its result is cached for the lifetime of the process.
+func IsAvailable() bool {
+	return Availability().Available
 }
 
-func IsAvailable() bool {
-	return _available
+// Availability reports whether io_uring can be used and, if not, why, so that callers
+// can log the reason before falling back to another I/O path
+func Availability() AvailabilityReport {
+	availabilityOnce.Do(func() {
+		availability = checkAvailability(probeSetup(1, Params{}))
+	})
+	return availability
+}
+
+// checkAvailability classifies the errno returned while setting up a ring
+func checkAvailability(errno syscall.Errno) AvailabilityReport {
+	var report AvailabilityReport
+	var uname unix.Utsname
+	if unix.Uname(&uname) == nil {
+		report.Kernel = string(bytes.TrimRight(uname.Release[:], "\x00"))
+	}
+
+	if errno == 0 {
+		report.Available = true
+		report.Reason = ReasonAvailable
+		return report
+	}
+	report.Err = fmt.Errorf("error while creating ring: %w", errno)
+
+	switch errno {
+	case syscall.ENOSYS:
+		// Seccomp filters can also make syscalls fail with ENOSYS, so kernels that
+		// are recent enough to have io_uring must be blocking it
+		report.Reason = ReasonUnsupportedKernel
+		if kernelAtLeast(report.Kernel, 5, 1) && seccompFiltered() {
+			report.Reason = ReasonDeniedBySeccomp
+		}
+	case syscall.EPERM, syscall.EACCES:
+		disabled, _ := readSysctl("io_uring_disabled")
+		switch disabled {
+		case 2:
+			report.Reason = ReasonDisabledBySysctl
+		case 1:
+			report.Reason = ReasonRestrictedToGroup
+			report.Group, _ = readSysctl("io_uring_group")
+		default:
+			report.Reason = ReasonDenied
+			if seccompFiltered() {
+				report.Reason = ReasonDeniedBySeccomp
+			}
+		}
+	default:
+		report.Reason = ReasonSetupFailed
+	}
+
+	return report
+}
+
+// readSysctl reads the integer value of the kernel sysctl name
+func readSysctl(name string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(procSysKernel, name))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// seccompFiltered reports whether the process runs under a seccomp filter
+func seccompFiltered() bool {
+	f, err := os.Open(procSelfStatus)
+	if err != nil {
+		return false
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "Seccomp:")
+		if ok {
+			return strings.TrimSpace(value) == "2"
+		}
+	}
+	return false
+}
+
+// kernelAtLeast reports whether release is at least major.minor
+func kernelAtLeast(release string, major int, minor int) bool {
+	version, err := ParseKernelVersion(release)
+	if err != nil {
+		return false
+	}
+	return version.AtLeast(KernelVersion{Major: major, Minor: minor})
 }
@@ -33,3 +33,10 @@ var (
 func IsAvailable() bool {
 	return available
 }
+
+func Availability() AvailabilityReport {
+	return AvailabilityReport{
+		Reason: ReasonUnsupportedPlatform,
+		Err:    ErrNotAvailable,
+	}
+}
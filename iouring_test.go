@@ -17,8 +17,16 @@
 package iouring
 
 import (
+	"context"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"os"
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestListener(t *testing.T) {
@@ -28,3 +36,332 @@ func TestListener(t *testing.T) {
 	err = l.Close()
 	require.NoError(t, err)
 }
+
+func TestListenerAccept(t *testing.T) {
+	l, err := NewListener("127.0.0.1:0")
+	require.NoError(t, err)
+
+	addr, ok := l.Addr().(*net.TCPAddr)
+	require.True(t, ok)
+	require.NotZero(t, addr.Port)
+
+	for i := 0; i < 4; i++ {
+		client, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+
+		server, err := l.Accept()
+		require.NoError(t, err)
+		require.IsType(t, new(Conn), server)
+		require.Equal(t, client.LocalAddr().String(), server.RemoteAddr().String())
+		require.Equal(t, client.RemoteAddr().String(), server.LocalAddr().String())
+
+		_, err = client.Write([]byte("hello"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(server, buf)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(buf))
+
+		require.NoError(t, server.Close())
+		require.NoError(t, client.Close())
+	}
+
+	err = l.Close()
+	require.NoError(t, err)
+
+	_, err = l.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestListenerMultishot(t *testing.T) {
+	l, err := ListenMultishot("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	const clients = 16
+	dialed := make(map[string]net.Conn, clients)
+	for i := 0; i < clients; i++ {
+		client, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		dialed[client.LocalAddr().String()] = client
+	}
+
+	for i := 0; i < clients; i++ {
+		server, err := l.Accept()
+		require.NoError(t, err)
+
+		client, ok := dialed[server.RemoteAddr().String()]
+		require.True(t, ok)
+		delete(dialed, server.RemoteAddr().String())
+
+		_, err = client.Write([]byte("hello"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(server, buf)
+		require.NoError(t, err)
+		require.Equal(t, "hello", string(buf))
+
+		require.NoError(t, server.Close())
+		require.NoError(t, client.Close())
+	}
+	require.Empty(t, dialed)
+	require.True(t, l.multishot)
+
+	err = l.Close()
+	require.NoError(t, err)
+
+	_, err = l.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestListenerDualStack(t *testing.T) {
+	if !supportsIPv4Map() {
+		t.Skip("dual-stack sockets are not supported on this host")
+	}
+
+	l, err := NewListener(":0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, l.Close())
+	})
+
+	port := l.Addr().(*net.TCPAddr).Port
+	for _, target := range []struct {
+		network string
+		addr    string
+	}{
+		{network: "tcp4", addr: "127.0.0.1"},
+		{network: "tcp6", addr: "::1"},
+	} {
+		client, err := Dial(target.network, net.JoinHostPort(target.addr, strconv.Itoa(port)))
+		require.NoError(t, err)
+
+		server, err := l.Accept()
+		require.NoError(t, err)
+
+		remoteAddr := server.RemoteAddr().(*net.TCPAddr)
+		require.True(t, remoteAddr.IP.Equal(net.ParseIP(target.addr)))
+		require.Equal(t, client.LocalAddr().(*net.TCPAddr).Port, remoteAddr.Port)
+
+		require.NoError(t, server.Close())
+		require.NoError(t, client.Close())
+	}
+}
+
+func TestListenerIPv6Only(t *testing.T) {
+	l, err := Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available on this host: %v", err)
+	}
+	t.Cleanup(func() {
+		require.NoError(t, l.Close())
+	})
+
+	addr := l.Addr().(*net.TCPAddr)
+	require.Nil(t, addr.IP.To4())
+
+	_, err = Dial("tcp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(addr.Port)))
+	require.Error(t, err)
+
+	client, err := Dial("tcp6", l.Addr().String())
+	require.NoError(t, err)
+
+	server, err := l.Accept()
+	require.NoError(t, err)
+	require.Equal(t, client.LocalAddr().String(), server.RemoteAddr().String())
+
+	require.NoError(t, server.Close())
+	require.NoError(t, client.Close())
+}
+
+func TestConn(t *testing.T) {
+	l, err := NewListener("127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, l.Close())
+	})
+
+	client, err := Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	require.Equal(t, l.Addr().String(), client.RemoteAddr().String())
+
+	server, err := l.Accept()
+	require.NoError(t, err)
+
+	payload := make([]byte, 1<<20)
+	_, err = rand.Read(payload)
+	require.NoError(t, err)
+
+	go func() {
+		_, err := client.Write(payload)
+		assert.NoError(t, err)
+	}()
+
+	received := make([]byte, len(payload))
+	_, err = io.ReadFull(server, received)
+	require.NoError(t, err)
+	require.Equal(t, payload, received)
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := server.Read(make([]byte, 16))
+		readErr <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, server.Close())
+	require.ErrorIs(t, <-readErr, net.ErrClosed)
+
+	_, err = client.Read(make([]byte, 16))
+	require.ErrorIs(t, err, io.EOF)
+
+	require.NoError(t, client.Close())
+	_, err = client.Read(make([]byte, 16))
+	require.ErrorIs(t, err, net.ErrClosed)
+	require.ErrorIs(t, server.Close(), net.ErrClosed)
+}
+
+func TestConnDeadline(t *testing.T) {
+	l, err := NewListener("127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, l.Close())
+	})
+
+	client, err := Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, client.Close())
+	})
+
+	server, err := l.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+	})
+
+	buf := make([]byte, 16)
+
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(20*time.Millisecond)))
+	start := time.Now()
+	_, err = server.Read(buf)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	require.True(t, netErr.Timeout())
+
+	_, err = server.Read(buf)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	require.NoError(t, server.SetReadDeadline(time.Time{}))
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := server.Read(buf)
+		readErr <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, server.SetReadDeadline(time.Unix(1, 0)))
+	require.ErrorIs(t, <-readErr, os.ErrDeadlineExceeded)
+
+	// A future deadline also applies to a read that is already blocked
+	require.NoError(t, server.SetReadDeadline(time.Time{}))
+	start = time.Now()
+	go func() {
+		_, err := server.Read(buf)
+		readErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	select {
+	case err = <-readErr:
+		require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+		require.Less(t, time.Since(start), time.Second)
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked read ignored the new deadline")
+	}
+
+	// Clearing the deadline of a blocked read keeps it waiting for data
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	read := make(chan string, 1)
+	go func() {
+		n, err := server.Read(buf)
+		if err != nil {
+			read <- err.Error()
+			return
+		}
+		read <- string(buf[:n])
+	}()
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, server.SetReadDeadline(time.Time{}))
+	time.Sleep(100 * time.Millisecond)
+	_, err = client.Write([]byte("late"))
+	require.NoError(t, err)
+	require.Equal(t, "late", <-read)
+
+	require.NoError(t, server.SetDeadline(time.Now().Add(time.Second)))
+	_, err = client.Write([]byte("hello"))
+	require.NoError(t, err)
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+
+	require.NoError(t, client.SetWriteDeadline(time.Now().Add(20*time.Millisecond)))
+	payload := make([]byte, 1<<20)
+	for err == nil {
+		_, err = client.Write(payload)
+	}
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestConnContext(t *testing.T) {
+	l, err := NewListener("127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, l.Close())
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = DialContext(ctx, "tcp", l.Addr().String())
+	require.ErrorIs(t, err, context.Canceled)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = l.AcceptContext(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	client, err := DialContext(context.Background(), "tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, client.Close())
+	})
+
+	server, err := l.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+	})
+
+	buf := make([]byte, 16)
+	ctx, cancel = context.WithCancel(context.Background())
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := server.(*Conn).ReadContext(ctx, buf)
+		readErr <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	require.ErrorIs(t, <-readErr, context.Canceled)
+
+	_, err = client.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	n, err := server.(*Conn).ReadContext(context.Background(), buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+}
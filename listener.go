@@ -16,12 +16,15 @@
 
 package iouring
 
-import "C"
 import (
+	"context"
 	"errors"
 	"fmt"
 	"golang.org/x/sys/unix"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
 	"syscall"
 )
 
@@ -29,6 +32,9 @@ var _ net.Listener = (*Listener)(nil)
 
 const (
 	AcceptEntries = 256
+
+	// DirectEntries is the size of the ring a direct Listener shares with the connections it accepts
+	DirectEntries = 1024
 )
 
 type Event uint64
@@ -40,17 +46,172 @@ const (
 	EventClose
 )
 
+type accepted struct {
+	l    *Listener
+	fd   int
+	addr net.Addr
+	err  error
+}
+
+// acceptQueue buffers the connections accepted by one or more listening sockets
+// until they are picked up by Accept
+type acceptQueue struct {
+	accepts   chan accepted
+	listeners []*Listener
+	closed    chan struct{}
+	done      chan struct{}
+	once      sync.Once
+	err       error
+}
+
+func newAcceptQueue(size int) *acceptQueue {
+	return &acceptQueue{
+		accepts: make(chan accepted, size),
+		closed:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// next waits for the next accepted connection and wraps it with the newConn of the
+// listener that accepted it
+func (q *acceptQueue) next(ctx context.Context) (net.Conn, error) {
+	select {
+	case a := <-q.accepts:
+		q.resume()
+		if a.err != nil {
+			return nil, a.err
+		}
+		conn, err := a.l.newConn(a.fd, a.addr)
+		if err != nil {
+			a.l.closeAccepted(a.fd)
+			return nil, err
+		}
+		return conn, nil
+	case <-q.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-q.done:
+		if q.err != nil {
+			return nil, q.err
+		}
+		return nil, net.ErrClosed
+	}
+}
+
+// resume lets the listeners that were paused because the queue was full accept again
+func (q *acceptQueue) resume() {
+	for _, l := range q.listeners {
+		l.resume()
+	}
+}
+
+// isClosed reports whether the listeners feeding the queue are being closed
+func (q *acceptQueue) isClosed() bool {
+	select {
+	case <-q.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// fail stops the queue with err once a listener can no longer accept connections
+func (q *acceptQueue) fail(err error) {
+	q.once.Do(func() {
+		q.err = err
+		close(q.done)
+	})
+}
+
+// drain closes the sockets of connections that were accepted but never picked up
+func (q *acceptQueue) drain() {
+	for {
+		select {
+		case a := <-q.accepts:
+			if a.err == nil {
+				a.l.closeAccepted(a.fd)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Listener is a net.Listener that accepts connections using an io_uring Ring
+//
+// A single accept request is kept in flight on the ring, and accepted connections are
+// buffered until they are picked up by Accept. In multishot mode the request stays armed
+// in the kernel and posts a completion for every accepted connection, so it only needs to
+// be re-armed when the kernel terminates it. Once the buffer is full the request is no
+// longer re-armed, and a multishot request is cancelled, so further connections wait in
+// the listen backlog until Accept makes room, without ever blocking the ring's dispatcher.
+//
+// In direct mode the accepted sockets are installed into the file table of the listener's
+// ring instead of the process fd table, and the connections keep submitting their requests
+// to that ring, which is closed once the listener and all of its connections are closed.
 type Listener struct {
-	ring *Ring
+	fd         int
+	network    string
+	addr       net.Addr
+	dispatcher *Dispatcher
+	shared     bool
+	multishot  bool
+	direct     *directRing
+	newConn    func(fd int, addr net.Addr) (net.Conn, error)
+
+	clientAddress *ClientAddress
+
+	armMu      sync.Mutex
+	token      uint64
+	armed      bool
+	parked     []accepted
+	paused     atomic.Bool
+	idle       chan struct{}
+	idleClosed bool
+
+	queue   *acceptQueue
+	closeMu sync.Mutex
 }
 
+// NewListener listens for TCP connections on addr, it is equivalent to calling
+// Listen with the "tcp" network
 func NewListener(addr string) (*Listener, error) {
-	tcpAddr, err := net.ResolveTCPAddr("tcp4", addr)
+	return Listen("tcp", addr)
+}
+
+// Listen listens for connections on addr, network must be "tcp", "tcp4" or "tcp6"
+func Listen(network string, addr string) (*Listener, error) {
+	return listen(network, addr, false, 0)
+}
+
+// ListenMultishot is like Listen, but arms a single multishot accept request that keeps
+// accepting connections without a new SQE being submitted for every client
+//
+// Kernels without multishot accept support (before 5.19) fall back to the regular mode.
+func ListenMultishot(network string, addr string) (*Listener, error) {
+	return listen(network, addr, true, 0)
+}
+
+// ListenDirect is like Listen, but accepts connections as direct descriptors into a file
+// table with slots entries, so accepted connections never use the process fd table
+//
+// All connections share the listener's ring, and at most slots of them can be open at once,
+// further accepts fail with ENFILE until a connection is closed. Direct accept requires
+// IORING_FILE_INDEX_ALLOC (Linux 5.19).
+func ListenDirect(network string, addr string, slots uint32) (*Listener, error) {
+	return listen(network, addr, false, slots)
+}
+
+// listen opens a listening socket bound to addr, a non-zero slots puts the listener into direct mode
+func listen(network string, addr string, multishot bool, slots uint32) (*Listener, error) {
+	tcpAddr, err := net.ResolveTCPAddr(network, addr)
 	if err != nil {
 		return nil, fmt.Errorf("error while resolving listen address: %w", err)
 	}
 
-	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	family, ipv6only := ipFamily(network, tcpAddr.IP, true)
+	fd, err := openIPSocket(family, syscall.SOCK_STREAM, ipv6only)
 	if err != nil {
 		return nil, fmt.Errorf("error while opening listening socket: %w", err)
 	}
@@ -58,34 +219,55 @@ func NewListener(addr string) (*Listener, error) {
 		return nil, fmt.Errorf("error while opening listening socket: fd is %d", fd)
 	}
 
-	err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+	err = bindTCP(fd, family, tcpAddr)
 	if err != nil {
-		return nil, fmt.Errorf("error while setting SO_REUSEADDR on listening socket with fd %d: %w", fd, err)
+		_ = syscall.Close(fd)
+		return nil, err
 	}
 
-	err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	var l *Listener
+	if slots > 0 {
+		l, err = newDirectListener(fd, network, slots)
+	} else {
+		l, err = newListener(fd, network, multishot, func(fd int, addr net.Addr) (net.Conn, error) {
+			return newConnFromFD(fd, network, addr)
+		})
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error while setting SO_REUSEPORT on listening socket with fd %d: %w", fd, err)
+		_ = syscall.Close(fd)
+		return nil, err
 	}
 
-	err = syscall.Bind(fd, &syscall.SockaddrInet4{
-		Port: tcpAddr.Port,
-		Addr: *(*[4]byte)(tcpAddr.IP),
-	})
+	return l, nil
+}
+
+func bindTCP(fd int, family int, tcpAddr *net.TCPAddr) error {
+	err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_REUSEADDR, 1)
 	if err != nil {
-		return nil, fmt.Errorf("error binding listening socket with fd %d to listen address %s: %w", fd, addr, err)
+		return fmt.Errorf("error while setting SO_REUSEADDR on listening socket with fd %d: %w", fd, err)
 	}
 
-	err = syscall.SetNonblock(fd, false)
+	err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	if err != nil {
+		return fmt.Errorf("error while setting SO_REUSEPORT on listening socket with fd %d: %w", fd, err)
+	}
+
+	sa, err := ipToSockaddr(family, tcpAddr.IP, tcpAddr.Port, tcpAddr.Zone)
 	if err != nil {
-		return nil, fmt.Errorf("error while setting listening socket with fd %d to blocking: %w", fd, err)
+		return fmt.Errorf("error while encoding listen address %s for listening socket with fd %d: %w", tcpAddr, fd, err)
 	}
 
-	err = syscall.Listen(fd, AcceptEntries/2)
+	err = syscall.Bind(fd, sa)
 	if err != nil {
-		return nil, fmt.Errorf("error while starting to listen on socket with fd %d: %w", fd, err)
+		return fmt.Errorf("error binding listening socket with fd %d to listen address %s: %w", fd, tcpAddr, err)
 	}
 
+	return nil
+}
+
+// newListener starts listening on the already bound socket fd with a ring of its own, newConn
+// is used to wrap every accepted socket before it is returned from Accept
+func newListener(fd int, network string, multishot bool, newConn func(fd int, addr net.Addr) (net.Conn, error)) (*Listener, error) {
 	ring, err := NewRing()
 	if err != nil {
 		return nil, fmt.Errorf("error while creating ring for listening socket with fd %d: %w", fd, err)
@@ -96,55 +278,347 @@ func NewListener(addr string) (*Listener, error) {
 		return nil, fmt.Errorf("error while initializng ring for listening socket with fd %d: %w", fd, err)
 	}
 
-	clientAddr := NewClientAddress()
-	sqe := ring.GetSQEntry()
-	sqe.PrepareAccept(fd, clientAddr.AddressPointer, clientAddr.LengthPointer, 0)
-	sqe.UserData = uint64(EventAccept)
+	l := &Listener{
+		fd:         fd,
+		network:    network,
+		dispatcher: NewDispatcher(ring),
+		multishot:  multishot,
+		newConn:    newConn,
+		queue:      newAcceptQueue(AcceptEntries / 2),
+	}
 
-	cqeNR, err := ring.Submit()
+	err = l.start()
 	if err != nil {
-		return nil, fmt.Errorf("error while submitting SQE for listening socket with fd %d: %w", fd, err)
+		_ = l.dispatcher.Close()
+		return nil, err
 	}
 
-	if cqeNR != 1 {
-		return nil, fmt.Errorf("error while submitting SQE for listening socket with fd %d: expected 1 CQE, got %d", fd, cqeNR)
-	}
+	return l, nil
+}
 
-	cqe, err := ring.WaitCQEvent()
-	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ETIME) {
-		return nil, fmt.Errorf("error while waiting for CQE for listening socket with fd %d: %w", fd, err)
+// start puts the bound socket of l into listening mode and arms its first accept request
+func (l *Listener) start() error {
+	err := syscall.SetNonblock(l.fd, false)
+	if err != nil {
+		return fmt.Errorf("error while setting listening socket with fd %d to blocking: %w", l.fd, err)
 	}
+
+	err = syscall.Listen(l.fd, AcceptEntries/2)
 	if err != nil {
-		return nil, fmt.Errorf("error while waiting for CQE for listening socket with fd %d: unknown - %w", fd, err)
+		return fmt.Errorf("error while starting to listen on socket with fd %d: %w", l.fd, err)
 	}
 
-	ring.CQESeen(cqe)
+	sa, err := syscall.Getsockname(l.fd)
+	if err != nil {
+		return fmt.Errorf("error while getting bound address of listening socket with fd %d: %w", l.fd, err)
+	}
 
-	for {
+	l.addr = sockaddrToAddr(l.network, sa)
+	l.clientAddress = NewClientAddress()
+	l.idle = make(chan struct{})
+	l.queue.listeners = append(l.queue.listeners, l)
 
-	}
+	l.armMu.Lock()
+	l.armed = true
+	l.armMu.Unlock()
 
-	err = ring.Close()
+	err = l.dispatcher.Submit(l.prepareAccept)
 	if err != nil {
-		return nil, fmt.Errorf("error while closing ringbuffer for listening socket with fd %d: %w", fd, err)
+		l.armMu.Lock()
+		l.armed = false
+		l.armMu.Unlock()
+		return fmt.Errorf("error while submitting SQE for listening socket with fd %d: %w", l.fd, err)
 	}
 
-	return &Listener{
-		ring: ring,
-	}, nil
+	return nil
 }
 
+// Accept waits for and returns the next connection accepted by the ring
 func (l *Listener) Accept() (net.Conn, error) {
-	//TODO implement me
-	panic("implement me")
+	return l.AcceptContext(context.Background())
 }
 
+// AcceptContext is like Accept, but returns ctx.Err() if ctx is done before a connection
+// has been accepted, connections accepted afterwards are returned by the next call
+func (l *Listener) AcceptContext(ctx context.Context) (net.Conn, error) {
+	conn, err := l.queue.next(ctx)
+	if err != nil {
+		return nil, l.opError(err)
+	}
+	return conn, nil
+}
+
+// Close cancels the in-flight accept request, waits for it to complete,
+// and then closes both the ring and the listening socket
 func (l *Listener) Close() error {
-	//TODO implement me
-	panic("implement me")
+	l.closeMu.Lock()
+	defer l.closeMu.Unlock()
+	if l.queue.isClosed() {
+		return l.opError(net.ErrClosed)
+	}
+	close(l.queue.closed)
+
+	err := l.stop()
+	l.queue.drain()
+
+	return err
+}
+
+// stop cancels the accept request of a listener whose queue has been closed, waits for it to
+// complete, and then closes the listening socket along with the ring if it is not shared
+func (l *Listener) stop() error {
+	l.armMu.Lock()
+	token, armed := l.token, l.armed
+	if !armed {
+		l.setIdle()
+	}
+	l.armMu.Unlock()
+
+	// If the cancel request cannot be submitted the dispatcher is closing or has failed,
+	// and either way the accept request completes without it
+	if armed {
+		_, _ = l.dispatcher.Cancel(token)
+	}
+	<-l.idle
+
+	l.armMu.Lock()
+	for _, a := range l.parked {
+		if a.err == nil {
+			l.closeAccepted(a.fd)
+		}
+	}
+	l.parked = nil
+	l.armMu.Unlock()
+
+	var dispatcherErr error
+	switch {
+	case l.direct != nil:
+		// Accepted connections have to be freed from the file table while the ring is still open
+		l.queue.drain()
+		dispatcherErr = l.direct.release()
+	case !l.shared:
+		dispatcherErr = l.dispatcher.Close()
+	}
+
+	err := syscall.Close(l.fd)
+	if dispatcherErr != nil {
+		return fmt.Errorf("error while closing dispatcher for listening socket with fd %d: %w", l.fd, dispatcherErr)
+	}
+	if err != nil {
+		return fmt.Errorf("error while closing listening socket with fd %d: %w", l.fd, err)
+	}
+
+	return nil
 }
 
+// Addr returns the address the listening socket is bound to
 func (l *Listener) Addr() net.Addr {
-	//TODO implement me
-	panic("implement me")
+	return l.addr
+}
+
+// prepareAccept prepares a new accept request, unless the listener is being closed, in which
+// case it fails with net.ErrClosed so that stop never misses the token it has to cancel
+//
+// Multishot requests are armed without a client address, since every completion would
+// overwrite the same address before the previous one has been read.
+func (l *Listener) prepareAccept(s *Submission) error {
+	sqe, err := s.Entry(ReceiverFunc(l.complete))
+	if err != nil {
+		return err
+	}
+
+	l.armMu.Lock()
+	defer l.armMu.Unlock()
+	if l.queue.isClosed() {
+		return net.ErrClosed
+	}
+	l.token = sqe.UserData
+
+	switch {
+	case l.direct != nil:
+		// Direct descriptors are never inherited, so SOCK_CLOEXEC is rejected
+		l.clientAddress.Reset()
+		sqe.PrepareAcceptDirect(l.fd, l.clientAddress.AddressPointer, l.clientAddress.LengthPointer, 0, FileIndexAlloc)
+	case l.multishot:
+		sqe.PrepareMultishotAccept(l.fd, 0, 0, syscall.SOCK_CLOEXEC)
+	default:
+		l.clientAddress.Reset()
+		sqe.PrepareAccept(l.fd, l.clientAddress.AddressPointer, l.clientAddress.LengthPointer, syscall.SOCK_CLOEXEC)
+	}
+	return nil
+}
+
+// acceptFailed handles an accept request posted by rearm that could not be submitted
+func (l *Listener) acceptFailed(err error) {
+	l.armMu.Lock()
+	defer l.armMu.Unlock()
+
+	l.armed = false
+	l.setIdle()
+	switch {
+	case errors.Is(err, net.ErrClosed):
+		// The listener is being closed, and stop is waiting for it to become idle
+		return
+	case errors.Is(err, ErrDispatcherClosed):
+		// The ring was closed by the RingGroup it belongs to
+		err = nil
+	default:
+		err = fmt.Errorf("error while submitting SQE for listening socket with fd %d: %w", l.fd, err)
+	}
+	l.queue.fail(err)
+}
+
+// complete handles a completion of the accept request, and re-arms the request
+// every time it completes without IORING_CQE_F_MORE set
+func (l *Listener) complete(c Completion) {
+	l.armMu.Lock()
+	defer l.armMu.Unlock()
+
+	more := c.More()
+	a := accepted{l: l}
+	if c.Res < 0 {
+		a.err = os.NewSyscallError("accept", syscall.Errno(-c.Res))
+	} else {
+		a.fd = int(c.Res)
+		a.addr = l.peerAddr(a.fd)
+	}
+
+	if l.multishot && !more && errors.Is(a.err, syscall.EINVAL) {
+		// The kernel does not support multishot accept, so fall back to
+		// arming a new accept request for every connection
+		l.multishot = false
+	} else if a.err == nil || !errors.Is(a.err, syscall.ECANCELED) {
+		l.deliver(a)
+	}
+
+	if more {
+		return
+	}
+	l.armed = false
+	l.rearm()
+}
+
+// deliver hands a to the queue without blocking, if the queue is full a is parked and the
+// listener pauses until Accept has made room, the caller must hold armMu
+func (l *Listener) deliver(a accepted) {
+	if len(l.parked) == 0 {
+		select {
+		case l.queue.accepts <- a:
+			return
+		case <-l.queue.closed:
+			if a.err == nil {
+				l.closeAccepted(a.fd)
+			}
+			return
+		default:
+		}
+
+		// Accept only resumes listeners that are paused, so the send is retried once the
+		// listener is marked as paused in case the queue was drained in the meantime
+		l.paused.Store(true)
+		select {
+		case l.queue.accepts <- a:
+			return
+		default:
+		}
+	}
+
+	l.parked = append(l.parked, a)
+	if len(l.parked) == 1 && l.multishot && l.armed {
+		// Completions that are posted before the cancel request are parked as well
+		token := l.token
+		l.dispatcher.Post(func(s *Submission) error {
+			sqe, err := s.Entry(nil)
+			if err != nil {
+				return err
+			}
+			sqe.PrepareCancel(token, 0)
+			return nil
+		}, nil)
+	}
+}
+
+// rearm posts a new accept request unless the listener is paused, closed or already armed,
+// the caller must hold armMu
+func (l *Listener) rearm() {
+	if l.idleClosed || l.armed || len(l.parked) > 0 {
+		return
+	}
+	if l.queue.isClosed() {
+		l.setIdle()
+		return
+	}
+
+	// The request is posted since rearm runs on the dispatcher when an accept request completes
+	l.armed = true
+	l.dispatcher.Post(l.prepareAccept, l.acceptFailed)
+}
+
+// resume moves the parked connections of a paused listener to the queue while there is room,
+// and re-arms the accept request once all of them have been queued
+func (l *Listener) resume() {
+	if !l.paused.Load() {
+		return
+	}
+
+	l.armMu.Lock()
+	defer l.armMu.Unlock()
+	for len(l.parked) > 0 {
+		select {
+		case l.queue.accepts <- l.parked[0]:
+			l.parked[0] = accepted{}
+			l.parked = l.parked[1:]
+		default:
+			return
+		}
+	}
+	l.paused.Store(false)
+	l.rearm()
+}
+
+// setIdle marks the listener as no longer having an accept request in flight for good,
+// the caller must hold armMu
+func (l *Listener) setIdle() {
+	if !l.idleClosed {
+		l.idleClosed = true
+		close(l.idle)
+	}
+}
+
+// peerAddr returns the address of the client connected to fd, multishot accept
+// requests do not fill in the client address so it is looked up with getpeername
+func (l *Listener) peerAddr(fd int) net.Addr {
+	if !l.multishot {
+		return l.clientAddress.Addr(l.network)
+	}
+
+	sa, err := syscall.Getpeername(fd)
+	if err != nil {
+		return nil
+	}
+	return sockaddrToAddr(l.network, sa)
+}
+
+// closeAccepted closes a connection that was accepted but never returned from Accept,
+// in direct mode fd is the slot of the connection in the file table
+func (l *Listener) closeAccepted(fd int) {
+	if l.direct != nil {
+		// The close request is posted, since closeAccepted also runs on the dispatcher
+		l.dispatcher.Post(func(s *Submission) error {
+			sqe, err := s.Entry(nil)
+			if err != nil {
+				return err
+			}
+			sqe.PrepareCloseDirect(uint32(fd))
+			return nil
+		}, func(error) {
+			_ = l.direct.files.Free(uint32(fd))
+		})
+		return
+	}
+	_ = syscall.Close(fd)
+}
+
+func (l *Listener) opError(err error) error {
+	return &net.OpError{Op: "accept", Net: l.network, Addr: l.addr, Err: err}
 }
@@ -0,0 +1,87 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"golang.org/x/sys/unix"
+	"syscall"
+	"unsafe"
+)
+
+// message holds a msghdr together with everything it points to, so that a single
+// heap allocation keeps all of it alive and in place while the kernel uses it
+type message struct {
+	hdr   syscall.Msghdr
+	iov   syscall.Iovec
+	name  unix.RawSockaddrAny
+	dummy [1]byte
+
+	buffer  []byte
+	control []byte
+}
+
+// newMessage builds a message that reads into or writes from b and oob, when b is
+// empty but oob is not and dummy is set a single dummy byte is used instead, since
+// non-datagram sockets can not carry ancillary data without any regular data
+func newMessage(b []byte, oob []byte, dummy bool) *message {
+	msg := &message{
+		buffer:  b,
+		control: oob,
+	}
+
+	if dummy && len(b) == 0 && len(oob) > 0 {
+		b = msg.dummy[:]
+	}
+	if len(b) > 0 {
+		msg.iov.Base = &b[0]
+		msg.iov.SetLen(len(b))
+		msg.hdr.Iov = &msg.iov
+		msg.hdr.Iovlen = 1
+	}
+	if len(oob) > 0 {
+		msg.hdr.Control = &oob[0]
+		msg.hdr.SetControllen(len(oob))
+	}
+
+	return msg
+}
+
+// withName makes the message carry a socket address, either to receive the
+// address of the peer or to set the destination of a write
+func (msg *message) withName() *ClientAddress {
+	msg.hdr.Name = (*byte)(unsafe.Pointer(&msg.name))
+	msg.hdr.Namelen = unix.SizeofSockaddrAny
+	return &ClientAddress{
+		Length:         &msg.hdr.Namelen,
+		LengthPointer:  uint64(uintptr(unsafe.Pointer(&msg.hdr.Namelen))),
+		Address:        &msg.name,
+		AddressPointer: uintptr(unsafe.Pointer(&msg.name)),
+	}
+}
+
+// pointer returns the address of the msghdr for use in a sendmsg or recvmsg request
+func (msg *message) pointer() uintptr {
+	return uintptr(unsafe.Pointer(&msg.hdr))
+}
+
+// n converts the result of a request back into the number of bytes of the caller's buffer
+func (msg *message) n(res int32) int {
+	if len(msg.buffer) == 0 {
+		return 0
+	}
+	return int(res)
+}
@@ -0,0 +1,290 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+var _ net.PacketConn = (*PacketConn)(nil)
+
+const (
+	PacketEntries = 256
+
+	// PacketBatchSize is the largest number of datagrams that are queued in a single
+	// Submit, it leaves enough room in the ring for a read and a write batch to be
+	// in flight at the same time
+	PacketBatchSize = PacketEntries / 4
+)
+
+// Message is a single datagram read by ReadBatch or written by WriteBatch
+type Message struct {
+	// Buffer holds the datagram's payload
+	Buffer []byte
+
+	// OOB holds the datagram's ancillary data
+	OOB []byte
+
+	// Addr is the source of a received datagram, or the destination of a written one
+	Addr net.Addr
+
+	// N is the number of bytes of Buffer that were read or written
+	N int
+
+	// NN is the number of bytes of OOB that were read or written
+	NN int
+
+	// Flags holds the flags returned by recvmsg for a received datagram
+	Flags int
+}
+
+// PacketConn is a net.PacketConn for UDP sockets whose reads and writes are submitted
+// to an io_uring Ring as recvmsg and sendmsg requests
+type PacketConn struct {
+	*Conn
+	family int
+}
+
+// ListenPacket opens a UDP socket bound to addr, network must be "udp", "udp4" or "udp6"
+func ListenPacket(network string, addr string) (*PacketConn, error) {
+	udpAddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("error while resolving listen address: %w", err)
+	}
+
+	family, ipv6only := ipFamily(network, udpAddr.IP, true)
+	fd, err := openIPSocket(family, syscall.SOCK_DGRAM, ipv6only)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening packet socket: %w", err)
+	}
+
+	sa, err := ipToSockaddr(family, udpAddr.IP, udpAddr.Port, udpAddr.Zone)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, fmt.Errorf("error while encoding listen address %s for packet socket with fd %d: %w", udpAddr, fd, err)
+	}
+
+	err = syscall.Bind(fd, sa)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, fmt.Errorf("error binding packet socket with fd %d to listen address %s: %w", fd, udpAddr, err)
+	}
+
+	ring, err := NewRing()
+	if err == nil {
+		err = ring.QueueInit(PacketEntries, 0)
+	}
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, fmt.Errorf("error while initializing ring for packet socket with fd %d: %w", fd, err)
+	}
+
+	conn, err := newConn(fd, network, NewDispatcher(ring), nil)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+
+	return &PacketConn{
+		Conn:   conn,
+		family: family,
+	}, nil
+}
+
+// ReadFrom submits a recvmsg request that reads a single datagram into b
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return c.ReadFromContext(context.Background(), b)
+}
+
+// ReadFromContext is like ReadFrom, but cancels the recvmsg request if ctx is done
+// before a datagram arrives, in which case ctx.Err() is returned
+func (c *PacketConn) ReadFromContext(ctx context.Context, b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	msg := newMessage(b, nil, false)
+	address := msg.withName()
+
+	c.readMsgs = []*message{msg}
+	res, err := c.do(ctx, EventRead, func(sqe *SQEntry) {
+		sqe.PrepareRecvMsg(c.fd, msg.pointer(), 0)
+	})
+	c.readMsgs = nil
+	if err != nil {
+		return 0, nil, c.opError("read", err)
+	}
+	if res < 0 {
+		return 0, nil, c.opError("read", c.resError("recvmsg", res))
+	}
+	if res == 0 && c.isClosed() {
+		return 0, nil, c.opError("read", net.ErrClosed)
+	}
+
+	return msg.n(res), address.Addr(c.network), nil
+}
+
+// WriteTo submits a sendmsg request that writes b as a single datagram to addr
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.WriteToContext(context.Background(), b, addr)
+}
+
+// WriteToContext is like WriteTo, but cancels the sendmsg request if ctx is done
+// before it completes, in which case ctx.Err() is returned
+func (c *PacketConn) WriteToContext(ctx context.Context, b []byte, addr net.Addr) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	msg := newMessage(b, nil, false)
+	err := c.setDestination(msg, addr)
+	if err != nil {
+		return 0, c.opError("write", err)
+	}
+
+	c.writeMsgs = []*message{msg}
+	res, err := c.do(ctx, EventWrite, func(sqe *SQEntry) {
+		sqe.PrepareSendMsg(c.fd, msg.pointer(), 0)
+	})
+	c.writeMsgs = nil
+	if err != nil {
+		return 0, c.opError("write", err)
+	}
+	if res < 0 {
+		return 0, c.opError("write", c.resError("sendmsg", res))
+	}
+
+	return msg.n(res), nil
+}
+
+// ReadBatch reads up to PacketBatchSize datagrams into ms with a single Submit, and returns
+// the number of messages that were filled in
+//
+// Like recvmmsg with MSG_WAITFORONE, it blocks until the first datagram arrives and then
+// only collects datagrams that are already queued on the socket.
+func (c *PacketConn) ReadBatch(ms []Message) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	if len(ms) > PacketBatchSize {
+		ms = ms[:PacketBatchSize]
+	}
+	if len(ms) == 0 {
+		return 0, nil
+	}
+
+	msgs := make([]*message, len(ms))
+	addresses := make([]*ClientAddress, len(ms))
+	for i := range ms {
+		msgs[i] = newMessage(ms[i].Buffer, ms[i].OOB, false)
+		addresses[i] = msgs[i].withName()
+	}
+
+	c.readMsgs = msgs
+	res, err := c.doBatch(EventRead, len(msgs), func(index int, sqe *SQEntry) {
+		var flags uint32
+		if index > 0 {
+			flags = syscall.MSG_DONTWAIT
+		}
+		sqe.PrepareRecvMsg(c.fd, msgs[index].pointer(), flags)
+	})
+	c.readMsgs = nil
+	if err != nil {
+		return 0, c.opError("read", err)
+	}
+
+	for i := range res {
+		if res[i] < 0 {
+			if i == 0 {
+				return 0, c.opError("read", c.resError("recvmsg", res[i]))
+			}
+			return i, nil
+		}
+		if i == 0 && res[i] == 0 && c.isClosed() {
+			return 0, c.opError("read", net.ErrClosed)
+		}
+
+		ms[i].N = msgs[i].n(res[i])
+		ms[i].NN = int(msgs[i].hdr.Controllen)
+		ms[i].Flags = int(msgs[i].hdr.Flags)
+		ms[i].Addr = addresses[i].Addr(c.network)
+	}
+
+	return len(res), nil
+}
+
+// WriteBatch writes the datagrams in ms, queueing up to PacketBatchSize of them in every Submit,
+// and returns the number of messages that were sent
+//
+// Like sendmmsg, the datagrams are sent in order and writing stops at the first failure.
+func (c *PacketConn) WriteBatch(ms []Message) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	var n int
+	for n < len(ms) {
+		batch := ms[n:]
+		if len(batch) > PacketBatchSize {
+			batch = batch[:PacketBatchSize]
+		}
+
+		msgs := make([]*message, len(batch))
+		for i := range batch {
+			msgs[i] = newMessage(batch[i].Buffer, batch[i].OOB, false)
+			err := c.setDestination(msgs[i], batch[i].Addr)
+			if err != nil {
+				return n, c.opError("write", err)
+			}
+		}
+
+		c.writeMsgs = msgs
+		res, err := c.doBatch(EventWrite, len(msgs), func(index int, sqe *SQEntry) {
+			sqe.PrepareSendMsg(c.fd, msgs[index].pointer(), 0)
+		})
+		c.writeMsgs = nil
+		if err != nil {
+			return n, c.opError("write", err)
+		}
+
+		for i := range res {
+			if res[i] < 0 {
+				return n, c.opError("write", c.resError("sendmsg", res[i]))
+			}
+			batch[i].N = msgs[i].n(res[i])
+			batch[i].NN = len(batch[i].OOB)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// setDestination encodes addr as the destination of msg, a nil addr leaves
+// the message without a destination so it is sent to the connected peer
+func (c *PacketConn) setDestination(msg *message, addr net.Addr) error {
+	if addr == nil {
+		return nil
+	}
+
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return &net.AddrError{Err: "unexpected address type", Addr: addr.String()}
+	}
+
+	return msg.withName().SetUDPAddr(c.family, udpAddr)
+}
@@ -0,0 +1,172 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestPacketConn(t *testing.T) {
+	server, err := ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, client.Close())
+	})
+
+	n, err := client.WriteTo([]byte("hello"), server.LocalAddr())
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	buf := make([]byte, 16)
+	n, addr, err := server.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+	require.Equal(t, client.LocalAddr().String(), addr.String())
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, _, err := server.ReadFrom(buf)
+		readErr <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, server.Close())
+	require.ErrorIs(t, <-readErr, net.ErrClosed)
+}
+
+func TestPacketConnBatch(t *testing.T) {
+	server, err := ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+	})
+
+	client, err := ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, client.Close())
+	})
+
+	const count = PacketBatchSize + PacketBatchSize/2
+	outgoing := make([]Message, count)
+	for i := range outgoing {
+		outgoing[i] = Message{
+			Buffer: []byte(strconv.Itoa(i)),
+			Addr:   server.LocalAddr(),
+		}
+	}
+
+	n, err := client.WriteBatch(outgoing)
+	require.NoError(t, err)
+	require.Equal(t, count, n)
+	for i := range outgoing {
+		require.Equal(t, len(outgoing[i].Buffer), outgoing[i].N)
+	}
+
+	incoming := make([]Message, PacketBatchSize)
+	for i := range incoming {
+		incoming[i].Buffer = make([]byte, 16)
+	}
+
+	var received int
+	for received < count {
+		n, err := server.ReadBatch(incoming)
+		require.NoError(t, err)
+		require.NotZero(t, n)
+		for i := 0; i < n; i++ {
+			require.Equal(t, strconv.Itoa(received), string(incoming[i].Buffer[:incoming[i].N]))
+			require.Equal(t, client.LocalAddr().String(), incoming[i].Addr.String())
+			received++
+		}
+	}
+}
+
+func TestPacketConnDeadline(t *testing.T) {
+	server, err := ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+	})
+
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(20*time.Millisecond)))
+	_, _, err = server.ReadFrom(make([]byte, 16))
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	incoming := make([]Message, 4)
+	for i := range incoming {
+		incoming[i].Buffer = make([]byte, 16)
+	}
+
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(20*time.Millisecond)))
+	_, err = server.ReadBatch(incoming)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	client, err := ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, client.Close())
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err = client.WriteTo([]byte(strconv.Itoa(i)), server.LocalAddr())
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := server.ReadBatch(incoming)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	for i := 0; i < n; i++ {
+		require.Equal(t, strconv.Itoa(i), string(incoming[i].Buffer[:incoming[i].N]))
+	}
+}
+
+func TestPacketConnContext(t *testing.T) {
+	server, err := ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err = server.ReadFromContext(ctx, make([]byte, 16))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	client, err := ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, client.Close())
+	})
+
+	_, err = client.WriteToContext(context.Background(), []byte("hello"), server.LocalAddr())
+	require.NoError(t, err)
+
+	buf := make([]byte, 16)
+	n, _, err := server.ReadFromContext(context.Background(), buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+}
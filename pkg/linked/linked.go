@@ -18,12 +18,17 @@ package linked
 
 import (
 	_ "unsafe"
+
+	_ "golang.org/x/sys/unix"
 )
 
-//go:linkname mmap syscall.mmap
+// The linker rejects references to syscall.mmap and syscall.munmap since Go 1.23, so the
+// identical functions of golang.org/x/sys/unix are linked instead
+
+//go:linkname mmap golang.org/x/sys/unix.mmap
 func mmap(uintptr, uintptr, int, int, int, int64) (uintptr, error)
 
-//go:linkname munmap syscall.munmap
+//go:linkname munmap golang.org/x/sys/unix.munmap
 func munmap(uintptr, uintptr) error
 
 func MMap(addr uintptr, length uintptr, prot int, flags int, fd int, offset int64) (uintptr, error) {
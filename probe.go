@@ -0,0 +1,107 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+const (
+	// probeOps is the number of operations liburing asks the kernel to report on
+	probeOps = 256
+)
+
+var (
+	probeOnce sync.Once
+	probe     *Probe
+	probeErr  error
+)
+
+// RegisterProbe is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L226
+func (r *Ring) RegisterProbe(p *Probe, nrOps uint32) (uint, error) {
+	return r.DoRegister(RegisterOpCodeRegisterProbe, unsafe.Pointer(p), nrOps)
+}
+
+// GetProbeRing is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/setup.c#L305
+func (r *Ring) GetProbeRing() (*Probe, error) {
+	p := new(Probe)
+	_, err := r.RegisterProbe(p, probeOps)
+	if err != nil {
+		return nil, fmt.Errorf("error while registering probe on ring with fd %d: %w", r.FD, err)
+	}
+	return p, nil
+}
+
+// GetProbe is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/setup.c#L321
+func GetProbe() (*Probe, error) {
+	ring, err := NewRing()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ring.QueueInit(2, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error while initializing ring for probe: %w", err)
+	}
+	defer func() {
+		_ = ring.Close()
+	}()
+
+	return ring.GetProbeRing()
+}
+
+// OpCodeSupported is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L199
+func (p *Probe) OpCodeSupported(op OpCode) bool {
+	if op > OpCode(p.LastOp) {
+		return false
+	}
+	return ProbeOpFlag(p.Ops[op].Flags)&ProbeOpFlagSupported != 0
+}
+
+// Supported returns every OpCode the kernel reported as supported
+func (p *Probe) Supported() []OpCode {
+	var ops []OpCode
+	for i := 0; i < int(p.OpsLen) && i < probeOps; i++ {
+		if ProbeOpFlag(p.Ops[i].Flags)&ProbeOpFlagSupported != 0 {
+			ops = append(ops, OpCode(p.Ops[i].Op))
+		}
+	}
+	return ops
+}
+
+// Supports reports whether the kernel the ring was set up on supports op, the probe is
+// registered the first time it is called and kernels without IORING_REGISTER_PROBE
+// (before 5.6) report no opcodes as supported
+func (r *Ring) Supports(op OpCode) bool {
+	r.probeOnce.Do(func() {
+		r.probe, _ = r.GetProbeRing()
+	})
+	return r.probe != nil && r.probe.OpCodeSupported(op)
+}
+
+// Supports reports whether the running kernel supports op, without an existing ring
+//
+// The kernel is probed once with a throwaway ring and the result is cached, so if io_uring
+// is not available at all no opcodes are reported as supported.
+func Supports(op OpCode) bool {
+	probeOnce.Do(func() {
+		probe, probeErr = GetProbe()
+	})
+	return probeErr == nil && probe.OpCodeSupported(op)
+}
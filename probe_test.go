@@ -0,0 +1,43 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	p, err := GetProbe()
+	require.NoError(t, err)
+	require.True(t, p.OpCodeSupported(OpCodeNOP))
+	require.True(t, p.OpCodeSupported(OpCodeAccept))
+	require.False(t, p.OpCodeSupported(OpCode(p.LastOp)+1))
+	require.Contains(t, p.Supported(), OpCodeRecv)
+
+	ring, err := NewRing()
+	require.NoError(t, err)
+	require.NoError(t, ring.QueueInit(2, 0))
+	t.Cleanup(func() {
+		_ = ring.Close()
+	})
+
+	for _, op := range []OpCode{OpCodeNOP, OpCodeSend, OpCodeRecv, OpCodeShutdown, OpCodeLast} {
+		require.Equal(t, p.OpCodeSupported(op), ring.Supports(op))
+		require.Equal(t, p.OpCodeSupported(op), Supports(op))
+	}
+}
@@ -0,0 +1,319 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var _ BufferGroup = (*ProvidedBuffers)(nil)
+
+// NewBufferGroup creates a BufferGroup with room for the given number of buffers on the ring
+// of d, which is a BufferRing on kernels that support it and ProvidedBuffers on older kernels
+//
+// entries must be a power of 2 no larger than MaxBufferRingEntries.
+func NewBufferGroup(d *Dispatcher, id uint16, entries uint32) (BufferGroup, error) {
+	group, err := d.Ring().RegisterBufferRing(id, entries)
+	if errors.Is(err, syscall.EINVAL) {
+		return NewProvidedBuffers(d, id, entries)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// ProvidedBuffers is a BufferGroup that hands its buffers to the kernel with provide
+// buffers requests, which works on kernels without buffer rings (Linux 5.7 and later)
+//
+// Every buffer that is added or recycled is provided again with its own request, which
+// is submitted through a Dispatcher. Recycle does not wait for the request to complete,
+// and a failure to replenish a buffer is passed to the function set with OnReplenishError,
+// or returned by the next call to Recycle if there is none. RecycleReceiver replenishes
+// the buffers of a group as soon as the completions that picked them have been handled.
+//
+// Add and Close wait for their requests to complete on the dispatcher, so they must not
+// be called from a Receiver of the same dispatcher.
+type ProvidedBuffers struct {
+	dispatcher *Dispatcher
+	id         uint16
+	entries    uint32
+
+	mu      sync.Mutex
+	buffers [][]byte
+	closed  bool
+
+	// errMu is separate from mu, since mu is held while submitting through the dispatcher
+	// that delivers the failures of provide buffers requests
+	errMu   sync.Mutex
+	err     error
+	onError func(err error)
+}
+
+// NewProvidedBuffers creates an empty buffer group id with room for the given number
+// of buffers, whose provide buffers requests are submitted through d
+func NewProvidedBuffers(d *Dispatcher, id uint16, entries uint32) (*ProvidedBuffers, error) {
+	if entries == 0 || entries > MaxBufferRingEntries {
+		return nil, fmt.Errorf("error while creating provided buffer group %d: entries must be between 1 and %d, got %d", id, MaxBufferRingEntries, entries)
+	}
+
+	return &ProvidedBuffers{
+		dispatcher: d,
+		id:         id,
+		entries:    entries,
+	}, nil
+}
+
+// ID returns the buffer group ID of the group
+func (p *ProvidedBuffers) ID() uint16 {
+	return p.id
+}
+
+// OnReplenishError sets the function that is called with the failure of every provide
+// buffers request submitted by Recycle, RecycleBatch or RecycleReceiver, which is called on
+// the dispatcher's goroutine unless a request posted by RecycleReceiver could not be submitted
+func (p *ProvidedBuffers) OnReplenishError(f func(err error)) {
+	p.errMu.Lock()
+	defer p.errMu.Unlock()
+	p.onError = f
+}
+
+// Add provides buf to the kernel, waits for the provide buffers request to complete,
+// and returns the buffer ID it was given
+func (p *ProvidedBuffers) Add(buf []byte) (uint16, error) {
+	if len(buf) == 0 {
+		return 0, ErrInvalidBufferID
+	}
+
+	id, err := p.reserve(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	// mu is not held while waiting, since Recycle is called from the receivers of the dispatcher
+	c, err := p.dispatcher.Do(context.Background(), func(sqe *SQEntry) {
+		sqe.PrepareProvideBuffers(uintptr(unsafe.Pointer(&buf[0])), uint32(len(buf)), 1, p.id, id)
+	})
+	if err == nil && c.Res < 0 {
+		err = os.NewSyscallError("provide_buffers", syscall.Errno(-c.Res))
+	}
+	if err != nil {
+		p.mu.Lock()
+		p.buffers[id] = nil
+		p.mu.Unlock()
+		return 0, fmt.Errorf("error while providing buffer %d to group %d: %w", id, p.id, err)
+	}
+
+	return id, nil
+}
+
+// reserve stores buf under the first free buffer ID of the group and returns the ID
+func (p *ProvidedBuffers) reserve(buf []byte) (uint16, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, ErrBufferGroupClosed
+	}
+
+	for id := range p.buffers {
+		if p.buffers[id] == nil {
+			p.buffers[id] = buf
+			return uint16(id), nil
+		}
+	}
+	if uint32(len(p.buffers)) == p.entries {
+		return 0, ErrBufferGroupFull
+	}
+	p.buffers = append(p.buffers, buf)
+
+	return uint16(len(p.buffers) - 1), nil
+}
+
+// Buffer returns the buffer with the given ID, or nil if there is none
+func (p *ProvidedBuffers) Buffer(id uint16) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if int(id) >= len(p.buffers) {
+		return nil
+	}
+	return p.buffers[id]
+}
+
+// Recycle provides the buffer with the given ID to the kernel again
+func (p *ProvidedBuffers) Recycle(id uint16) error {
+	return p.RecycleBatch(id)
+}
+
+// RecycleBatch provides the buffers with the given IDs to the kernel again with a
+// single submission
+func (p *ProvidedBuffers) RecycleBatch(ids ...uint16) error {
+	return p.recycle(true, ids)
+}
+
+// recycle provides the buffers with the given IDs to the kernel again, when wait is false the
+// submission is posted to the dispatcher instead, and a failure to submit it is reported like
+// a failure to replenish the buffers
+func (p *ProvidedBuffers) recycle(wait bool, ids []uint16) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrBufferGroupClosed
+	}
+	p.errMu.Lock()
+	err := p.err
+	p.err = nil
+	p.errMu.Unlock()
+	if err != nil {
+		p.mu.Unlock()
+		return err
+	}
+	for _, id := range ids {
+		if int(id) >= len(p.buffers) || p.buffers[id] == nil {
+			p.mu.Unlock()
+			return ErrInvalidBufferID
+		}
+	}
+	p.mu.Unlock()
+
+	prepare := func(s *Submission) error {
+		// Buffers that are recycled after Close has removed the group are not provided again
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.closed {
+			return ErrBufferGroupClosed
+		}
+
+		for _, id := range ids {
+			id := id
+			sqe, err := s.Entry(ReceiverFunc(func(c Completion) {
+				p.replenished(id, c)
+			}))
+			if err != nil {
+				return err
+			}
+			buf := p.buffers[id]
+			sqe.PrepareProvideBuffers(uintptr(unsafe.Pointer(&buf[0])), uint32(len(buf)), 1, p.id, id)
+		}
+		return nil
+	}
+
+	if !wait {
+		p.dispatcher.Post(prepare, func(err error) {
+			if !errors.Is(err, ErrBufferGroupClosed) {
+				p.report(fmt.Errorf("error while submitting provide buffers requests for group %d: %w", p.id, err))
+			}
+		})
+		return nil
+	}
+
+	err = p.dispatcher.Submit(prepare)
+	if err != nil {
+		return fmt.Errorf("error while submitting provide buffers requests for group %d: %w", p.id, err)
+	}
+
+	return nil
+}
+
+// replenished reports the failure of a provide buffers request submitted by RecycleBatch
+func (p *ProvidedBuffers) replenished(id uint16, c Completion) {
+	if c.Res >= 0 {
+		return
+	}
+
+	p.report(fmt.Errorf("error while replenishing buffer %d of group %d: %w", id, p.id, os.NewSyscallError("provide_buffers", syscall.Errno(-c.Res))))
+}
+
+// report passes err to the function set with OnReplenishError, or keeps it for the next
+// call to Recycle if there is none
+func (p *ProvidedBuffers) report(err error) {
+	p.errMu.Lock()
+	onError := p.onError
+	if onError == nil && p.err == nil {
+		p.err = err
+	}
+	p.errMu.Unlock()
+
+	if onError != nil {
+		onError(err)
+	}
+}
+
+// RecycleReceiver wraps r in a Receiver that recycles the buffer a completion picked from
+// group once r has handled the completion, so r must be done with the buffer by then
+//
+// Recycling failures are passed to failed, which may be nil, and both r and failed are
+// called on the dispatcher's goroutine. The buffers of a ProvidedBuffers group are posted
+// to be provided again, so a failure to submit them is reported like a failure to
+// replenish them.
+func RecycleReceiver(group BufferGroup, r Receiver, failed func(err error)) Receiver {
+	return ReceiverFunc(func(c Completion) {
+		r.Deliver(c)
+
+		id, ok := c.BufferID()
+		if !ok {
+			return
+		}
+		// Provided buffers are posted to be recycled, since Recycle would wait for the
+		// submitter, which may itself be waiting for the dispatcher to reap completions
+		var err error
+		if p, ok := group.(*ProvidedBuffers); ok {
+			err = p.recycle(false, []uint16{id})
+		} else {
+			err = group.Recycle(id)
+		}
+		if err != nil && failed != nil {
+			failed(err)
+		}
+	})
+}
+
+// Close removes the buffers that are still provided to the kernel from the group, the
+// buffers that were added to it belong to the caller again
+func (p *ProvidedBuffers) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrBufferGroupClosed
+	}
+	p.closed = true
+	n := len(p.buffers)
+	p.mu.Unlock()
+
+	if n == 0 {
+		return nil
+	}
+
+	c, err := p.dispatcher.Do(context.Background(), func(sqe *SQEntry) {
+		sqe.PrepareRemoveBuffers(n, p.id)
+	})
+	// ENOENT means that every buffer of the group is in use
+	if err == nil && c.Res < 0 && c.Res != -int32(syscall.ENOENT) {
+		err = os.NewSyscallError("remove_buffers", syscall.Errno(-c.Res))
+	}
+	if err != nil {
+		return fmt.Errorf("error while removing buffers from group %d: %w", p.id, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestProvidedBuffers(t *testing.T) {
+	d := newTestDispatcher(t, 16)
+	t.Cleanup(func() {
+		_ = d.Close()
+	})
+
+	group, err := NewProvidedBuffers(d, 1, 2)
+	require.NoError(t, err)
+	require.Equal(t, uint16(1), group.ID())
+
+	id, err := group.Add(make([]byte, 16))
+	require.NoError(t, err)
+	require.Zero(t, id)
+
+	var fds [2]int
+	require.NoError(t, syscall.Pipe(fds[:]))
+	t.Cleanup(func() {
+		_ = syscall.Close(fds[0])
+		_ = syscall.Close(fds[1])
+	})
+
+	read := func(data string) Completion {
+		_, err := syscall.Write(fds[1], []byte(data))
+		require.NoError(t, err)
+
+		c, err := d.Do(context.Background(), func(sqe *SQEntry) {
+			sqe.PrepareRW(OpCodeRead, fds[0], 0, 16, 0)
+			sqe.SetBufferSelect(group.ID())
+		})
+		require.NoError(t, err)
+		return c
+	}
+
+	c := read("hello")
+	require.Equal(t, int32(5), c.Res)
+	id, ok := c.BufferID()
+	require.True(t, ok)
+	require.Zero(t, id)
+	require.Equal(t, "hello", string(group.Buffer(id)[:c.Res]))
+
+	c = read("world")
+	require.Equal(t, -int32(syscall.ENOBUFS), c.Res)
+
+	// The buffer is provided again once it has been recycled
+	require.NoError(t, group.Recycle(id))
+	c = read("")
+	require.Equal(t, int32(5), c.Res)
+	id, ok = c.BufferID()
+	require.True(t, ok)
+	require.Equal(t, "world", string(group.Buffer(id)[:c.Res]))
+	require.NoError(t, group.Recycle(id))
+
+	id, err = group.Add(make([]byte, 16))
+	require.NoError(t, err)
+	require.Equal(t, uint16(1), id)
+	_, err = group.Add(make([]byte, 16))
+	require.ErrorIs(t, err, ErrBufferGroupFull)
+	require.ErrorIs(t, group.Recycle(2), ErrInvalidBufferID)
+
+	require.NoError(t, group.Close())
+	require.ErrorIs(t, group.Close(), ErrBufferGroupClosed)
+	c = read("again")
+	require.Equal(t, -int32(syscall.ENOBUFS), c.Res)
+}
+
+func TestRecycleReceiver(t *testing.T) {
+	d := newTestDispatcher(t, 16)
+	t.Cleanup(func() {
+		_ = d.Close()
+	})
+
+	group, err := NewProvidedBuffers(d, 1, 1)
+	require.NoError(t, err)
+	group.OnReplenishError(func(err error) {
+		t.Errorf("unexpected replenish error: %v", err)
+	})
+	_, err = group.Add(make([]byte, 16))
+	require.NoError(t, err)
+
+	var fds [2]int
+	require.NoError(t, syscall.Pipe(fds[:]))
+	t.Cleanup(func() {
+		_ = syscall.Close(fds[0])
+		_ = syscall.Close(fds[1])
+	})
+
+	// The group holds a single buffer, so every read after the first one relies on it being recycled
+	var read string
+	recycling := RecycleReceiver(group, ReceiverFunc(func(c Completion) {
+		id, ok := c.BufferID()
+		if !ok {
+			read = c.Err().Error()
+			return
+		}
+		read = string(group.Buffer(id)[:c.Res])
+	}), func(err error) {
+		t.Errorf("unexpected recycle error: %v", err)
+	})
+
+	// The read is only reported once the buffer has been posted to be provided again
+	reads := make(chan string, 1)
+	r := ReceiverFunc(func(c Completion) {
+		recycling.Deliver(c)
+		reads <- read
+	})
+	for _, data := range []string{"hello", "world", "again"} {
+		_, err = syscall.Write(fds[1], []byte(data))
+		require.NoError(t, err)
+
+		// The buffer may not have been provided again yet, in which case the read fails
+		// with ENOBUFS without consuming the data, and is retried
+		for attempt := 0; ; attempt++ {
+			err = d.Submit(func(s *Submission) error {
+				sqe, err := s.Entry(r)
+				if err != nil {
+					return err
+				}
+				sqe.PrepareRW(OpCodeRead, fds[0], 0, 16, 0)
+				sqe.SetBufferSelect(group.ID())
+				return nil
+			})
+			require.NoError(t, err)
+
+			got := <-reads
+			if got == syscall.ENOBUFS.Error() && attempt < 100 {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			require.Equal(t, data, got)
+			break
+		}
+	}
+
+	require.NoError(t, group.Close())
+}
+
+func TestNewBufferGroup(t *testing.T) {
+	d := newTestDispatcher(t, 16)
+	t.Cleanup(func() {
+		_ = d.Close()
+	})
+
+	group, err := NewBufferGroup(d, 1, 4)
+	require.NoError(t, err)
+	if Supports(OpCodeReadMultishot) {
+		// Every kernel with multishot reads has buffer rings
+		require.IsType(t, (*BufferRing)(nil), group)
+	}
+	require.NoError(t, group.Close())
+}
@@ -20,6 +20,7 @@ import (
 	"golang.org/x/sys/unix"
 	"runtime"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -61,7 +62,7 @@ func (r *Ring) FlushSQ() uint32 {
 	// There is a potential race condition here, left
 	// intentionally because it will not cause any issues
 	// https://github.com/axboe/liburing/blob/liburing-2.4/src/queue.c#L219
-	return tail - *r.SQ.KHead
+	return tail - atomic.LoadUint32(r.SQ.KHead)
 }
 
 // GetCQEvent is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/queue.c#L135
@@ -149,6 +150,138 @@ func (r *Ring) _GetCQEvent(data *GetData) (cqe *CQEvent, err error) {
 	return
 }
 
+// _SubmitTimeout is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/queue.c#L269
+func (r *Ring) _SubmitTimeout(waitNR uint32, ts *KernelTimespec) (uint32, error) {
+	sqe := r.GetSQEntry()
+	if sqe == nil {
+		_, err := r.Submit()
+		if err != nil {
+			return 0, err
+		}
+		sqe = r.GetSQEntry()
+		if sqe == nil {
+			return 0, unix.EAGAIN
+		}
+	}
+
+	sqe.PrepareTimeout(ts, waitNR, 0)
+	sqe.UserData = LIBURING_UDATA_TIMEOUT
+	r.timeoutArmed = true
+
+	return r.FlushSQ(), nil
+}
+
+// removeTimeout removes the timeout SQE armed by _SubmitTimeout once the wait it was armed for
+// has returned before it completed, so it cannot fail a later wait with ETIME
+//
+// Both the completion of the timeout SQE and that of the remove request are left for
+// consumeTimeout to discard, whichever way the timeout SQE completes.
+func (r *Ring) removeTimeout() {
+	sqe := r.GetSQEntry()
+	if sqe == nil {
+		_, err := r.Submit()
+		if err != nil {
+			return
+		}
+		sqe = r.GetSQEntry()
+		if sqe == nil {
+			return
+		}
+	}
+
+	sqe.PrepareTimeoutRemove(LIBURING_UDATA_TIMEOUT, 0)
+	sqe.UserData = LIBURING_UDATA_TIMEOUT
+	r.timeoutArmed = false
+	r.staleTimeouts += 2
+
+	// If the remove request cannot be submitted now it is submitted along with the next SQEs,
+	// which still happens before the timeout SQE of the next wait is armed
+	_, _ = r.Submit()
+}
+
+// consumeTimeout records that the completion of a timeout SQE or of its remove request has been
+// consumed, and reports whether it belongs to an earlier wait than the current one
+func (r *Ring) consumeTimeout() bool {
+	if r.staleTimeouts > 0 {
+		r.staleTimeouts--
+		return true
+	}
+	r.timeoutArmed = false
+	return false
+}
+
+// _WaitCQEventsNew is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/queue.c#L245
+func (r *Ring) _WaitCQEventsNew(waitNR uint32, ts *KernelTimespec, sigmask *unix.Sigset_t) (*CQEvent, error) {
+	arg := GetEventsArg{
+		SigMask:     uint64(uintptr(unsafe.Pointer(sigmask))),
+		SigMaskSize: _NSIG / 8,
+		TS:          uint64(uintptr(unsafe.Pointer(ts))),
+	}
+	data := GetData{
+		WaitNR:   waitNR,
+		GetFlags: uint32(EnterExtArg),
+		Size:     int(unsafe.Sizeof(arg)),
+		Arg:      unsafe.Pointer(&arg),
+	}
+	if ts != nil {
+		data.HasTS = 1
+	}
+
+	cqe, err := r._GetCQEvent(&data)
+	runtime.KeepAlive(ts)
+	runtime.KeepAlive(sigmask)
+
+	return cqe, err
+}
+
+// WaitCQEvents is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/queue.c#L300
+//
+// Without FeatureExtArg the timeout is implemented with a timeout SQE, which also submits every
+// SQE that is waiting in the SQ, and whose completion is consumed by the ring itself. A timeout
+// SQE that is still pending when the wait returns is removed again.
+func (r *Ring) WaitCQEvents(waitNR uint32, ts *KernelTimespec, sigmask *unix.Sigset_t) (*CQEvent, error) {
+	var submit uint32
+	if ts != nil {
+		if r.Features&uint32(FeatureExtArg) != 0 {
+			return r._WaitCQEventsNew(waitNR, ts, sigmask)
+		}
+
+		var err error
+		submit, err = r._SubmitTimeout(waitNR, ts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cqe, err := r.GetCQEvent(submit, waitNR, sigmask)
+	runtime.KeepAlive(ts)
+	if r.timeoutArmed {
+		r.removeTimeout()
+	}
+
+	return cqe, err
+}
+
+// WaitCQEventsTimeout waits until waitNR completions are available or timeout has passed,
+// and returns the first completion, it fails with ETIME if no completion is available by then
+func (r *Ring) WaitCQEventsTimeout(waitNR uint32, timeout time.Duration) (*CQEvent, error) {
+	if timeout < 0 {
+		timeout = 0
+	}
+	ts := KernelTimespec{
+		Sec:  int64(timeout / time.Second),
+		Nsec: int64(timeout % time.Second),
+	}
+	return r.WaitCQEvents(waitNR, &ts, nil)
+}
+
+// WaitCQEventTimeout is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/queue.c#L358
+//
+// It fails with ETIME if no completion arrived before timeout passed.
+func (r *Ring) WaitCQEventTimeout(timeout time.Duration) (*CQEvent, error) {
+	return r.WaitCQEventsTimeout(1, timeout)
+}
+
 // _Submit is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/queue.c#L368
 func (r *Ring) _Submit(submitted uint32, waitNR uint32, getEvents bool) (ret uint, err error) {
 	cqNeedsEnter := getEvents || waitNR != 0 || r.CQNeedsEnter()
@@ -163,8 +296,10 @@ func (r *Ring) _Submit(submitted uint32, waitNR uint32, getEvents bool) (ret uin
 			flags |= uint32(EnterRegisteredRing)
 		}
 
+		r.submitEntered = true
 		ret, err = r.Enter(submitted, waitNR, flags, nil)
 	} else {
+		r.submitEntered = false
 		ret = uint(submitted)
 	}
 	return
@@ -180,6 +315,29 @@ func (r *Ring) Submit() (uint, error) {
 	return r._SubmitAndWait(0)
 }
 
+// SubmitEntered reports whether the last Submit had to call io_uring_enter, on SQPOLL
+// rings it is false while the SQPOLL thread is awake and picks up new SQEs by itself
+func (r *Ring) SubmitEntered() bool {
+	return r.submitEntered
+}
+
+// SQRingWait is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/queue.c
+//
+// It waits for the SQPOLL thread to consume SQEs when the SQ is full, and returns
+// immediately for rings without SQPOLL or with space left in the SQ.
+func (r *Ring) SQRingWait() error {
+	if r.Flags&uint32(SetupSQPoll) == 0 || r.SQSpaceLeft() > 0 {
+		return nil
+	}
+
+	flags := uint32(EnterSQWait)
+	if r.IntFlags&uint8(IntFlagRegRing) != 0 {
+		flags |= uint32(EnterRegisteredRing)
+	}
+	_, err := r.Enter(0, 0, flags, nil)
+	return err
+}
+
 // SubmitAndGetEvents is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/queue.c#L415
 func (r *Ring) SubmitAndGetEvents() (uint, error) {
 	return r._Submit(r.FlushSQ(), 0, true)
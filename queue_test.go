@@ -0,0 +1,158 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// peekCQEvent spins on the CQ without entering the kernel until a CQE arrives or timeout passes
+func peekCQEvent(t *testing.T, ring *Ring, timeout time.Duration) *CQEvent {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		cqe, err := ring._PeekCQEvent(nil)
+		require.NoError(t, err)
+		if cqe != nil {
+			return cqe
+		}
+		runtime.Gosched()
+	}
+	require.FailNow(t, "timed out waiting for CQE")
+	return nil
+}
+
+func TestSQPoll(t *testing.T) {
+	ring, err := NewRing()
+	require.NoError(t, err)
+	require.NoError(t, ring.QueueInitConfig(NewRingConfig(16, WithSQPoll(10*time.Second))))
+	t.Cleanup(func() {
+		_ = ring.Close()
+	})
+
+	const requests = 128
+	var entered int
+	for i := 0; i < requests; i++ {
+		sqe := ring.GetSQEntry()
+		require.NotNil(t, sqe)
+		sqe.PrepareNop()
+		sqe.UserData = uint64(i)
+
+		n, err := ring.Submit()
+		require.NoError(t, err)
+		require.Equal(t, uint(1), n)
+		if ring.SubmitEntered() {
+			entered++
+		}
+
+		cqe := peekCQEvent(t, ring, 5*time.Second)
+		require.Equal(t, uint64(i), cqe.UserData)
+		require.Zero(t, cqe.Res)
+		ring.CQESeen(cqe)
+	}
+
+	// The SQPOLL thread may still be starting up when the first request is submitted,
+	// after that it stays awake and no submission needs a syscall
+	require.LessOrEqual(t, entered, 1)
+}
+
+func TestSQPollWakeup(t *testing.T) {
+	ring, err := NewRing()
+	require.NoError(t, err)
+	require.NoError(t, ring.QueueInitConfig(NewRingConfig(16, WithSQPoll(time.Millisecond))))
+
+	for i := 0; i < 3; i++ {
+		deadline := time.Now().Add(5 * time.Second)
+		for !ring.SQNeedsWakeup() {
+			require.True(t, time.Now().Before(deadline), "SQPOLL thread did not go idle")
+			time.Sleep(time.Millisecond)
+		}
+
+		sqe := ring.GetSQEntry()
+		require.NotNil(t, sqe)
+		sqe.PrepareNop()
+
+		_, err = ring.Submit()
+		require.NoError(t, err)
+		require.True(t, ring.SubmitEntered())
+
+		cqe := peekCQEvent(t, ring, 5*time.Second)
+		require.Zero(t, cqe.Res)
+		ring.CQESeen(cqe)
+	}
+
+	d := NewDispatcher(ring)
+	for i := 0; i < 64; i++ {
+		c, err := d.Do(context.Background(), func(sqe *SQEntry) {
+			sqe.PrepareNop()
+		})
+		require.NoError(t, err)
+		require.NoError(t, c.Err())
+	}
+
+	require.NoError(t, d.Close())
+}
+
+func TestWaitCQEventTimeout(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	for _, extArg := range []bool{true, false} {
+		ring, err := NewRing()
+		require.NoError(t, err)
+		require.NoError(t, ring.QueueInit(8, 0))
+		if !extArg {
+			// Force the fallback to a timeout SQE
+			ring.Features &^= uint32(FeatureExtArg)
+		}
+
+		expectTimeout := func(waitNR uint32) {
+			start := time.Now()
+			cqe, err := ring.WaitCQEventsTimeout(waitNR, timeout)
+			require.ErrorIs(t, err, syscall.ETIME)
+			require.Nil(t, cqe)
+			require.GreaterOrEqual(t, time.Since(start), timeout)
+		}
+		expectTimeout(1)
+
+		sqe := ring.GetSQEntry()
+		require.NotNil(t, sqe)
+		sqe.PrepareNop()
+		sqe.UserData = 1
+		_, err = ring.Submit()
+		require.NoError(t, err)
+
+		cqe, err := ring.WaitCQEventTimeout(timeout)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), cqe.UserData)
+
+		// Only one of the two completions arrives, which is returned once the timeout passes
+		cqe, err = ring.WaitCQEventsTimeout(2, timeout)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), cqe.UserData)
+		ring.CQESeen(cqe)
+
+		// The timeouts of the waits that returned early must not cut later waits short
+		time.Sleep(2 * timeout)
+		expectTimeout(1)
+		expectTimeout(1)
+
+		require.NoError(t, ring.Close())
+	}
+}
@@ -17,6 +17,7 @@
 package iouring
 
 import (
+	"runtime"
 	"syscall"
 	"unsafe"
 )
@@ -34,3 +35,134 @@ func (r *Ring) DoRegister(opCode RegisterOpCode, arg unsafe.Pointer, NRArgs uint
 func (r *Ring) RegisterBuffers(iovecs []syscall.Iovec, NRIOVecs uint32) (uint, error) {
 	return r.DoRegister(RegisterOpCodeRegisterBuffers, unsafe.Pointer(&iovecs[0]), NRIOVecs)
 }
+
+// RegisterBuffersTags is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L32
+func (r *Ring) RegisterBuffersTags(iovecs []syscall.Iovec, tags []uint64) (uint, error) {
+	reg := RsrcRegister{
+		NR:   uint32(len(iovecs)),
+		Data: uint64(uintptr(unsafe.Pointer(&iovecs[0]))),
+		Tags: uint64(uintptr(unsafe.Pointer(&tags[0]))),
+	}
+
+	res, err := r.DoRegister(RegisterOpCodeRegisterBuffers2, unsafe.Pointer(&reg), uint32(unsafe.Sizeof(reg)))
+	runtime.KeepAlive(iovecs)
+	runtime.KeepAlive(tags)
+	return res, err
+}
+
+// RegisterBuffersSparse is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L47
+func (r *Ring) RegisterBuffersSparse(nr uint32) (uint, error) {
+	reg := RsrcRegister{
+		NR:    nr,
+		Flags: uint32(RsrcRegisterFlagSparse),
+	}
+
+	return r.DoRegister(RegisterOpCodeRegisterBuffers2, unsafe.Pointer(&reg), uint32(unsafe.Sizeof(reg)))
+}
+
+// UnregisterBuffers is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L66
+func (r *Ring) UnregisterBuffers() (uint, error) {
+	return r.DoRegister(RegisterOpCodeUnregisterBuffers, nil, 0)
+}
+
+// RegisterBuffersUpdateTag is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L17
+func (r *Ring) RegisterBuffersUpdateTag(offset uint32, iovecs []syscall.Iovec, tags []uint64) (uint, error) {
+	update := RsrcUpdate2{
+		Offset: offset,
+		Data:   uint64(uintptr(unsafe.Pointer(&iovecs[0]))),
+		Tags:   uint64(uintptr(unsafe.Pointer(&tags[0]))),
+		NR:     uint32(len(iovecs)),
+	}
+
+	res, err := r.DoRegister(RegisterOpCodeRegisterBuffersUpdate, unsafe.Pointer(&update), uint32(unsafe.Sizeof(update)))
+	runtime.KeepAlive(iovecs)
+	runtime.KeepAlive(tags)
+	return res, err
+}
+
+// RegisterFiles is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L153
+func (r *Ring) RegisterFiles(files []int32) (uint, error) {
+	res, err := r.DoRegister(RegisterOpCodeRegisterFiles, unsafe.Pointer(&files[0]), uint32(len(files)))
+	runtime.KeepAlive(files)
+	return res, err
+}
+
+// RegisterFilesTags is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L106
+func (r *Ring) RegisterFilesTags(files []int32, tags []uint64) (uint, error) {
+	reg := RsrcRegister{
+		NR:   uint32(len(files)),
+		Data: uint64(uintptr(unsafe.Pointer(&files[0]))),
+		Tags: uint64(uintptr(unsafe.Pointer(&tags[0]))),
+	}
+
+	res, err := r.DoRegister(RegisterOpCodeRegisterFiles2, unsafe.Pointer(&reg), uint32(unsafe.Sizeof(reg)))
+	runtime.KeepAlive(files)
+	runtime.KeepAlive(tags)
+	return res, err
+}
+
+// RegisterFilesSparse is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L128
+func (r *Ring) RegisterFilesSparse(nr uint32) (uint, error) {
+	reg := RsrcRegister{
+		NR:    nr,
+		Flags: uint32(RsrcRegisterFlagSparse),
+	}
+
+	return r.DoRegister(RegisterOpCodeRegisterFiles2, unsafe.Pointer(&reg), uint32(unsafe.Sizeof(reg)))
+}
+
+// UnregisterFiles is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L182
+func (r *Ring) UnregisterFiles() (uint, error) {
+	return r.DoRegister(RegisterOpCodeUnregisterFiles, nil, 0)
+}
+
+// RegisterFilesUpdate is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L89
+func (r *Ring) RegisterFilesUpdate(offset uint32, files []int32) (uint, error) {
+	update := FilesUpdate{
+		Offset: offset,
+		FDs:    uint64(uintptr(unsafe.Pointer(&files[0]))),
+	}
+
+	res, err := r.DoRegister(RegisterOpCodeRegisterFilesUpdate, unsafe.Pointer(&update), uint32(len(files)))
+	runtime.KeepAlive(files)
+	return res, err
+}
+
+// RegisterFilesUpdateTag is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L72
+func (r *Ring) RegisterFilesUpdateTag(offset uint32, files []int32, tags []uint64) (uint, error) {
+	update := RsrcUpdate2{
+		Offset: offset,
+		Data:   uint64(uintptr(unsafe.Pointer(&files[0]))),
+		Tags:   uint64(uintptr(unsafe.Pointer(&tags[0]))),
+		NR:     uint32(len(files)),
+	}
+
+	res, err := r.DoRegister(RegisterOpCodeRegisterFilesUpdate2, unsafe.Pointer(&update), uint32(unsafe.Sizeof(update)))
+	runtime.KeepAlive(files)
+	runtime.KeepAlive(tags)
+	return res, err
+}
+
+// RegisterFileAllocRange is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L351
+func (r *Ring) RegisterFileAllocRange(offset uint32, length uint32) (uint, error) {
+	fileRange := FileIndexRange{
+		Offset: offset,
+		Length: length,
+	}
+
+	return r.DoRegister(RegisterOpCodeRegisterFileAllocRange, unsafe.Pointer(&fileRange), 0)
+}
+
+// RegisterBufRing is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L334
+func (r *Ring) RegisterBufRing(reg *BufReg) (uint, error) {
+	return r.DoRegister(RegisterOpCodeRegisterPbufRing, unsafe.Pointer(reg), 1)
+}
+
+// UnregisterBufRing is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/register.c#L340
+func (r *Ring) UnregisterBufRing(bufferGroup uint16) (uint, error) {
+	reg := BufReg{
+		BufferGroup: bufferGroup,
+	}
+
+	return r.DoRegister(RegisterOpCodeUnregisterPbufRing, unsafe.Pointer(&reg), 1)
+}
@@ -19,11 +19,18 @@
 package iouring
 
 import (
+	"errors"
+	"github.com/loopholelabs/iouring/pkg/linked"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"unsafe"
 )
 
+var (
+	ErrSQFull = errors.New("submission queue is full")
+)
+
 var (
 	emptyCQEvent CQEvent
 	emptySQEntry SQEntry
@@ -44,6 +51,18 @@ type Ring struct {
 	IntFlags    uint8
 	_Pad        [3]uint8
 	_Pad2       uint32
+
+	// submitEntered records whether the last Submit had to call io_uring_enter
+	submitEntered bool
+
+	// timeoutArmed records whether the timeout SQE of the last WaitCQEvents without FeatureExtArg
+	// has yet to complete, and staleTimeouts counts the completions of the timeout SQEs of earlier
+	// calls that were removed and have not been consumed yet
+	timeoutArmed  bool
+	staleTimeouts uint32
+
+	probeOnce sync.Once
+	probe     *Probe
 }
 
 func NewRing() (*Ring, error) {
@@ -55,13 +74,64 @@ func (r *Ring) GetSQEntry() *SQEntry {
 	head := atomic.LoadUint32(r.SQ.KHead)
 	next := r.SQ.SQETail + 1
 	if next-head <= r.SQ.RingEntries {
-		sqe := (*SQEntry)(unsafe.Add(unsafe.Pointer(r.SQ.SQEs), uintptr(r.SQ.SQETail&r.SQ.RingMask)*sqEntrySize))
+		sqe := (*SQEntry)(unsafe.Add(unsafe.Pointer(r.SQ.SQEs), uintptr(r.SQ.SQETail&r.SQ.RingMask)<<r.sqeShift()*sqEntrySize))
 		r.SQ.SQETail = next
 		return sqe
 	}
 	return nil
 }
 
+// sqeShift is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L1310
+func (r *Ring) sqeShift() uint32 {
+	if r.Flags&uint32(SetupSQE128) != 0 {
+		return 1
+	}
+	return 0
+}
+
+// cqeShift is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L252
+func (r *Ring) cqeShift() uint32 {
+	if r.Flags&uint32(SetupCQE32) != 0 {
+		return 1
+	}
+	return 0
+}
+
+// cqeAt returns the CQE at index of the CQ, taking the size of big CQEs into account
+func (r *Ring) cqeAt(index uint32) *CQEvent {
+	return (*CQEvent)(unsafe.Add(unsafe.Pointer(r.CQ.CQEs), uintptr(index<<r.cqeShift())*cqEventSize))
+}
+
+// SQReady is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (r *Ring) SQReady() uint32 {
+	head := *r.SQ.KHead
+	if r.Flags&uint32(SetupSQPoll) != 0 {
+		head = atomic.LoadUint32(r.SQ.KHead)
+	}
+	return r.SQ.SQETail - head
+}
+
+// FeatureSet returns the features the kernel reported when the ring was set up
+func (r *Ring) FeatureSet() Feature {
+	return Feature(r.Features)
+}
+
+// SetupFlags returns the flags the ring was set up with
+func (r *Ring) SetupFlags() Setup {
+	return Setup(r.Flags)
+}
+
+// SQNeedsWakeup reports whether the SQPOLL thread has gone to sleep, in which case the
+// next Submit wakes it up with io_uring_enter
+func (r *Ring) SQNeedsWakeup() bool {
+	return r.Flags&uint32(SetupSQPoll) != 0 && atomic.LoadUint32(r.SQ.KFlags)&uint32(SQStatusNeedWakeup) != 0
+}
+
+// SQSpaceLeft is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h
+func (r *Ring) SQSpaceLeft() uint32 {
+	return r.SQ.RingEntries - r.SQReady()
+}
+
 // WaitCQEvent is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L1304
 func (r *Ring) WaitCQEvent() (*CQEvent, error) {
 	cqe, err := r._PeekCQEvent(nil)
@@ -91,12 +161,10 @@ func (r *Ring) _PeekCQEvent(nrAvailable *uint32) (cqe *CQEvent, err error) {
 			break
 		}
 
-		cqe = (*CQEvent)(
-			unsafe.Add(unsafe.Pointer(r.CQ.CQEs), uintptr(head&mask)*cqEventSize),
-		)
+		cqe = r.cqeAt(head & mask)
 
 		if r.Features&uint32(FeatureExtArg) == 0 && cqe.UserData == LIBURING_UDATA_TIMEOUT {
-			if cqe.Res < 0 {
+			if !r.consumeTimeout() && cqe.Res < 0 {
 				err = syscall.Errno(uintptr(-cqe.Res))
 			}
 			r.CQAdvance(1)
@@ -126,6 +194,85 @@ func (r *Ring) PeekCQEvent() (cqe *CQEvent, err error) {
 	return r.WaitCQEventNR(0)
 }
 
+// CQReady is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L1180
+func (r *Ring) CQReady() uint32 {
+	return atomic.LoadUint32(r.CQ.KTail) - *r.CQ.KHead
+}
+
+// PeekBatchCQE is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/queue.c#L160
+//
+// It fills dst with up to len(dst) of the CQEs that are ready and returns how many it filled
+// in, without consuming them, so they must be released with CQAdvance once they have been handled.
+func (r *Ring) PeekBatchCQE(dst []*CQEvent) int {
+	overflowChecked := false
+	for {
+		ready := r.CQReady()
+		if ready > 0 {
+			count := uint32(len(dst))
+			if count > ready {
+				count = ready
+			}
+
+			head := *r.CQ.KHead
+			for i := uint32(0); i < count; i++ {
+				dst[i] = r.cqeAt((head + i) & r.CQ.RingMask)
+			}
+			return int(count)
+		}
+
+		if overflowChecked || !r.CQNeedsFlush() {
+			return 0
+		}
+
+		flags := uint32(EnterGetEvents)
+		if r.IntFlags&uint8(IntFlagRegRing) != 0 {
+			flags |= uint32(EnterRegisteredRing)
+		}
+		_, _ = r.Enter(0, 0, flags, nil)
+		overflowChecked = true
+	}
+}
+
+// ForEachCQE calls fn for every CQE between the head and the tail of the CQ, and then
+// consumes all of them with a single CQAdvance, it returns the number of CQEs consumed
+//
+// Iteration stops early if fn returns false, the CQE fn was called with is still consumed.
+// Completions of the timeout SQEs used by WaitCQEvents on kernels without FeatureExtArg
+// are consumed without being passed to fn.
+func (r *Ring) ForEachCQE(fn func(cqe *CQEvent) bool) int {
+	head := *r.CQ.KHead
+	tail := atomic.LoadUint32(r.CQ.KTail)
+	skipTimeouts := r.Features&uint32(FeatureExtArg) == 0
+
+	var count uint32
+	for head+count != tail {
+		cqe := r.cqeAt((head + count) & r.CQ.RingMask)
+		count++
+		if skipTimeouts && cqe.UserData == LIBURING_UDATA_TIMEOUT {
+			r.consumeTimeout()
+			continue
+		}
+		if !fn(cqe) {
+			break
+		}
+	}
+	r.CQAdvance(count)
+
+	return int(count)
+}
+
+// BigCQE returns the 16 bytes that follow the CQE on rings set up with SetupCQE32,
+// it must not be called for CQEs of other rings
+func (c *CQEvent) BigCQE() *[2]uint64 {
+	return (*[2]uint64)(unsafe.Add(unsafe.Pointer(c), cqEventSize))
+}
+
+// BufferID returns the ID of the provided buffer a buffer select request picked, the
+// second result is false if the request completed without picking a buffer
+func (c *CQEvent) BufferID() (uint16, bool) {
+	return CQEventFlag(c.Flags).BufferID()
+}
+
 // CQESeen is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L319
 func (r *Ring) CQESeen(cqe *CQEvent) {
 	if cqe != nil {
@@ -140,7 +287,23 @@ func (r *Ring) CQAdvance(numCQEs uint32) {
 	}
 }
 
+// Close unmaps the rings and closes the ring fd, closing a ring again fails with EBADF
 func (r *Ring) Close() error {
+	if r.FD < 0 {
+		return syscall.EBADF
+	}
+
+	if r.SQ.SQEs != nil {
+		sqeSize, _ := entrySizes(r.Flags)
+		_ = linked.MUnmap(uintptr(unsafe.Pointer(r.SQ.SQEs)), sqeSize*uintptr(r.SQ.RingEntries))
+	}
 	MUnmap(&r.SQ, &r.CQ)
-	return syscall.Close(r.FD)
+
+	// Dropping the mappings and the fd makes closing the ring again a no-op instead of
+	// unmapping memory or closing an fd that has since been reused
+	r.SQ, r.CQ = SubmissionQueue{}, CompletionQueue{}
+	fd := r.FD
+	r.FD = -1
+
+	return syscall.Close(fd)
 }
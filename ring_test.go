@@ -0,0 +1,146 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func newTestRing(t *testing.T, entries uint32) *Ring {
+	ring, err := NewRing()
+	require.NoError(t, err)
+	require.NoError(t, ring.QueueInit(entries, 0))
+	t.Cleanup(func() {
+		_ = ring.Close()
+	})
+
+	return ring
+}
+
+func submitNops(t *testing.T, ring *Ring, n int) {
+	for i := 0; i < n; i++ {
+		sqe := ring.GetSQEntry()
+		require.NotNil(t, sqe)
+		sqe.PrepareNop()
+		sqe.UserData = uint64(i)
+	}
+	_, err := ring._SubmitAndWait(uint32(n))
+	require.NoError(t, err)
+}
+
+func TestPeekBatchCQE(t *testing.T) {
+	ring := newTestRing(t, 16)
+
+	cqes := make([]*CQEvent, 4)
+	require.Zero(t, ring.PeekBatchCQE(cqes))
+
+	submitNops(t, ring, 10)
+	require.Equal(t, uint32(10), ring.CQReady())
+
+	var next uint64
+	for ring.CQReady() > 0 {
+		n := ring.PeekBatchCQE(cqes)
+		require.LessOrEqual(t, n, len(cqes))
+		for _, cqe := range cqes[:n] {
+			require.Equal(t, next, cqe.UserData)
+			next++
+		}
+		ring.CQAdvance(uint32(n))
+	}
+	require.Equal(t, uint64(10), next)
+
+	submitNops(t, ring, 16)
+	allocs := testing.AllocsPerRun(10, func() {
+		ring.CQAdvance(uint32(ring.PeekBatchCQE(cqes[:1])))
+	})
+	require.Zero(t, allocs)
+}
+
+func TestForEachCQE(t *testing.T) {
+	ring := newTestRing(t, 16)
+
+	require.Zero(t, ring.ForEachCQE(func(cqe *CQEvent) bool {
+		return true
+	}))
+
+	submitNops(t, ring, 12)
+
+	var seen []uint64
+	require.Equal(t, 4, ring.ForEachCQE(func(cqe *CQEvent) bool {
+		seen = append(seen, cqe.UserData)
+		return len(seen) < 4
+	}))
+	require.Equal(t, uint32(8), ring.CQReady())
+
+	require.Equal(t, 8, ring.ForEachCQE(func(cqe *CQEvent) bool {
+		seen = append(seen, cqe.UserData)
+		return true
+	}))
+	require.Zero(t, ring.CQReady())
+	require.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, seen)
+
+	submitNops(t, ring, 16)
+	var count int
+	fn := func(cqe *CQEvent) bool {
+		count++
+		return true
+	}
+	allocs := testing.AllocsPerRun(1, func() {
+		ring.ForEachCQE(fn)
+	})
+	require.Zero(t, allocs)
+	require.Equal(t, 16, count)
+}
+
+func TestBigEntries(t *testing.T) {
+	ring, err := NewRing()
+	require.NoError(t, err)
+	require.NoError(t, ring.QueueInitConfig(NewRingConfig(4, WithSQE128(), WithCQE32())))
+	t.Cleanup(func() {
+		_ = ring.Close()
+	})
+
+	var next uint64
+	for round := 0; round < 4; round++ {
+		for i := 0; i < 4; i++ {
+			sqe := ring.GetSQEntry()
+			require.NotNil(t, sqe)
+			sqe.PrepareNop()
+			sqe.UserData = next + uint64(i)
+
+			// Fill the command area, which must not spill into the next SQE
+			command := sqe.Command()
+			for j := range command {
+				command[j] = 0xff
+			}
+		}
+		_, err = ring._SubmitAndWait(4)
+		require.NoError(t, err)
+
+		cqes := make([]*CQEvent, 8)
+		n := ring.PeekBatchCQE(cqes)
+		require.Equal(t, 4, n)
+		for _, cqe := range cqes[:n] {
+			require.Equal(t, next, cqe.UserData)
+			require.Zero(t, cqe.Res)
+			require.Equal(t, [2]uint64{}, *cqe.BigCQE())
+			next++
+		}
+		ring.CQAdvance(uint32(n))
+	}
+}
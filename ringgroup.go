@@ -0,0 +1,269 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+var _ net.Listener = (*GroupListener)(nil)
+
+const (
+	GroupEntries = 1024
+)
+
+var (
+	ErrRingGroupClosed = errors.New("ring group is closed")
+)
+
+// RingGroup shards work across a fixed number of rings, each shard has its own Dispatcher
+// and therefore its own completion loop
+//
+// Shards are not tied to CPUs, their dispatchers run on ordinary goroutines that the Go
+// scheduler is free to move between threads, so sharding only spreads the submissions and
+// completions of the group across several rings.
+//
+// Listeners created by the group open one SO_REUSEPORT listening socket per shard, so the
+// kernel balances incoming connections across the shards, and every accepted connection
+// stays on the shard it was accepted by. Other work is spread across the shards by hashing
+// a key such as a file descriptor with ShardFor.
+type RingGroup struct {
+	shards []*Dispatcher
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewRingGroup creates a group of n rings, or of GOMAXPROCS rings if n is not positive,
+// which only sizes the group and does not pin any shard to a CPU
+func NewRingGroup(n int) (*RingGroup, error) {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+
+	g := &RingGroup{
+		shards: make([]*Dispatcher, 0, n),
+	}
+	for i := 0; i < n; i++ {
+		ring, err := NewRing()
+		if err == nil {
+			err = ring.QueueInit(GroupEntries, 0)
+		}
+		if err != nil {
+			_ = g.Close()
+			return nil, fmt.Errorf("error while initializing ring for shard %d of ring group: %w", i, err)
+		}
+		g.shards = append(g.shards, NewDispatcher(ring))
+	}
+
+	return g, nil
+}
+
+// Len returns the number of shards in the group
+func (g *RingGroup) Len() int {
+	return len(g.shards)
+}
+
+// Shard returns the dispatcher of shard i
+func (g *RingGroup) Shard(i int) *Dispatcher {
+	return g.shards[i]
+}
+
+// ShardFor returns the dispatcher of the shard that key hashes onto, the same key always
+// maps to the same shard so all the work for a file or connection stays on one ring
+func (g *RingGroup) ShardFor(key uint64) *Dispatcher {
+	return g.shards[g.shardIndex(key)]
+}
+
+// shardIndex maps key onto a shard using Fibonacci hashing, which spreads sequential
+// keys such as file descriptors evenly across the shards
+func (g *RingGroup) shardIndex(key uint64) int {
+	return int((key * 0x9e3779b97f4a7c15 >> 32) % uint64(len(g.shards)))
+}
+
+// NewConn wraps an already connected TCP socket, taking ownership of fd, and submits its
+// requests to the shard that fd hashes onto
+func (g *RingGroup) NewConn(fd int, remoteAddr *net.TCPAddr) (*Conn, error) {
+	var addr net.Addr
+	if remoteAddr != nil {
+		addr = remoteAddr
+	}
+	return newSharedConn(fd, "tcp", g.ShardFor(uint64(fd)), addr)
+}
+
+// Listen listens for connections on addr with one listening socket per shard, network
+// must be "tcp", "tcp4" or "tcp6"
+func (g *RingGroup) Listen(network string, addr string) (*GroupListener, error) {
+	return g.listen(network, addr, false)
+}
+
+// ListenMultishot is like Listen, but arms a multishot accept request on every shard
+func (g *RingGroup) ListenMultishot(network string, addr string) (*GroupListener, error) {
+	return g.listen(network, addr, true)
+}
+
+func (g *RingGroup) listen(network string, addr string, multishot bool) (*GroupListener, error) {
+	g.mu.Lock()
+	closed := g.closed
+	g.mu.Unlock()
+	if closed {
+		return nil, ErrRingGroupClosed
+	}
+
+	tcpAddr, err := net.ResolveTCPAddr(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("error while resolving listen address: %w", err)
+	}
+
+	gl := &GroupListener{
+		network:   network,
+		listeners: make([]*Listener, 0, len(g.shards)),
+		queue:     newAcceptQueue(AcceptEntries / 2 * len(g.shards)),
+	}
+
+	family, ipv6only := ipFamily(network, tcpAddr.IP, true)
+	for i, shard := range g.shards {
+		l, err := g.listenShard(network, family, ipv6only, tcpAddr, shard, gl.queue, multishot)
+		if err != nil {
+			_ = gl.Close()
+			return nil, fmt.Errorf("error while listening on shard %d of ring group: %w", i, err)
+		}
+		gl.listeners = append(gl.listeners, l)
+
+		if i == 0 {
+			// Bind the remaining shards to the port the first one was given
+			gl.addr = l.addr
+			tcpAddr = &net.TCPAddr{IP: tcpAddr.IP, Port: l.addr.(*net.TCPAddr).Port, Zone: tcpAddr.Zone}
+		}
+	}
+
+	return gl, nil
+}
+
+// listenShard opens a listening socket bound to tcpAddr whose accept requests are submitted to shard,
+// and whose accepted connections are queued on queue and submit their requests to shard as well
+func (g *RingGroup) listenShard(network string, family int, ipv6only bool, tcpAddr *net.TCPAddr, shard *Dispatcher, queue *acceptQueue, multishot bool) (*Listener, error) {
+	fd, err := openIPSocket(family, syscall.SOCK_STREAM, ipv6only)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening listening socket: %w", err)
+	}
+
+	err = bindTCP(fd, family, tcpAddr)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+
+	l := &Listener{
+		fd:         fd,
+		network:    network,
+		dispatcher: shard,
+		shared:     true,
+		multishot:  multishot,
+		newConn: func(fd int, addr net.Addr) (net.Conn, error) {
+			return newSharedConn(fd, network, shard, addr)
+		},
+		queue: queue,
+	}
+
+	err = l.start()
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Close closes the rings of every shard, which cancels all requests that are still in flight,
+// connections and listeners created by the group must be closed first
+func (g *RingGroup) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return ErrRingGroupClosed
+	}
+	g.closed = true
+
+	var errs []error
+	for i, shard := range g.shards {
+		err := shard.Close()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error while closing dispatcher for shard %d of ring group: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// GroupListener is a net.Listener that accepts connections from the listening sockets of
+// every shard of a RingGroup, which are all bound to the same address with SO_REUSEPORT
+type GroupListener struct {
+	network   string
+	addr      net.Addr
+	listeners []*Listener
+	queue     *acceptQueue
+	closeMu   sync.Mutex
+}
+
+// Accept waits for and returns the next connection accepted by any of the shards
+func (l *GroupListener) Accept() (net.Conn, error) {
+	return l.AcceptContext(context.Background())
+}
+
+// AcceptContext is like Accept, but returns ctx.Err() if ctx is done before a connection
+// has been accepted, connections accepted afterwards are returned by the next call
+func (l *GroupListener) AcceptContext(ctx context.Context) (net.Conn, error) {
+	conn, err := l.queue.next(ctx)
+	if err != nil {
+		return nil, &net.OpError{Op: "accept", Net: l.network, Addr: l.addr, Err: err}
+	}
+	return conn, nil
+}
+
+// Close cancels the accept requests of every shard, waits for them to complete, and then
+// closes the listening sockets, the rings are left open since they belong to the RingGroup
+func (l *GroupListener) Close() error {
+	l.closeMu.Lock()
+	defer l.closeMu.Unlock()
+	if l.queue.isClosed() {
+		return &net.OpError{Op: "accept", Net: l.network, Addr: l.addr, Err: net.ErrClosed}
+	}
+	close(l.queue.closed)
+
+	var errs []error
+	for _, listener := range l.listeners {
+		err := listener.stop()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	l.queue.drain()
+
+	return errors.Join(errs...)
+}
+
+// Addr returns the address the listening sockets are bound to
+func (l *GroupListener) Addr() net.Addr {
+	return l.addr
+}
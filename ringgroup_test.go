@@ -0,0 +1,178 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRingGroup(t *testing.T) {
+	g, err := NewRingGroup(4)
+	require.NoError(t, err)
+	require.Equal(t, 4, g.Len())
+
+	shards := make(map[*Dispatcher]struct{})
+	for key := uint64(0); key < 64; key++ {
+		shard := g.ShardFor(key)
+		require.Same(t, shard, g.ShardFor(key))
+		shards[shard] = struct{}{}
+	}
+	require.Len(t, shards, g.Len())
+
+	for _, multishot := range []bool{false, true} {
+		var l *GroupListener
+		if multishot {
+			l, err = g.ListenMultishot("tcp", "127.0.0.1:0")
+		} else {
+			l, err = g.Listen("tcp", "127.0.0.1:0")
+		}
+		require.NoError(t, err)
+		require.Len(t, l.listeners, g.Len())
+		for _, listener := range l.listeners {
+			require.Equal(t, l.Addr().String(), listener.Addr().String())
+		}
+
+		const clients = 32
+		dialed := make(map[string]net.Conn, clients)
+		for i := 0; i < clients; i++ {
+			client, err := net.Dial("tcp", l.Addr().String())
+			require.NoError(t, err)
+			dialed[client.LocalAddr().String()] = client
+		}
+
+		for i := 0; i < clients; i++ {
+			server, err := l.Accept()
+			require.NoError(t, err)
+
+			client, ok := dialed[server.RemoteAddr().String()]
+			require.True(t, ok)
+			delete(dialed, server.RemoteAddr().String())
+
+			_, err = client.Write([]byte("hello"))
+			require.NoError(t, err)
+
+			buf := make([]byte, 5)
+			_, err = io.ReadFull(server, buf)
+			require.NoError(t, err)
+			require.Equal(t, "hello", string(buf))
+
+			require.NoError(t, server.Close())
+			require.NoError(t, client.Close())
+		}
+		require.Empty(t, dialed)
+
+		require.NoError(t, l.Close())
+		_, err = l.Accept()
+		require.ErrorIs(t, err, net.ErrClosed)
+	}
+
+	require.NoError(t, g.Close())
+	require.ErrorIs(t, g.Close(), ErrRingGroupClosed)
+
+	_, err = g.Listen("tcp", "127.0.0.1:0")
+	require.ErrorIs(t, err, ErrRingGroupClosed)
+}
+
+// fillAcceptQueue accepts a single connection from l, then dials more clients than the accept
+// queue holds without accepting them, and checks that the accepted connection, which shares
+// the listener's ring, keeps completing I/O while the queue is full
+//
+// The clients that do not fit into the queue wait in the listen backlog, and there are fewer of
+// them than the backlog holds, so the kernel never drops their SYNs and the dials do not stall.
+func fillAcceptQueue(t *testing.T, l net.Listener) (client net.Conn, server net.Conn, pending []net.Conn) {
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	server, err = l.Accept()
+	require.NoError(t, err)
+
+	const clients = AcceptEntries/2 + AcceptEntries/8
+	pending = make([]net.Conn, 0, clients)
+	for i := 0; i < clients; i++ {
+		c, err := net.DialTimeout("tcp", l.Addr().String(), 10*time.Second)
+		require.NoError(t, err)
+		pending = append(pending, c)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 5)
+		_, err := io.ReadFull(server, buf)
+		if err == nil {
+			_, err = server.Write(buf)
+		}
+		done <- err
+	}()
+	_, err = client.Write([]byte("hello"))
+	require.NoError(t, err)
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection I/O stalled while the accept queue was full")
+	}
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(client, buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf))
+
+	return client, server, pending
+}
+
+func TestGroupListenerFullQueue(t *testing.T) {
+	g, err := NewRingGroup(1)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, g.Close())
+	})
+
+	for _, multishot := range []bool{false, true} {
+		var l *GroupListener
+		if multishot {
+			l, err = g.ListenMultishot("tcp", "127.0.0.1:0")
+		} else {
+			l, err = g.Listen("tcp", "127.0.0.1:0")
+		}
+		require.NoError(t, err)
+
+		client, server, pending := fillAcceptQueue(t, l)
+		for range pending {
+			c, err := l.Accept()
+			require.NoError(t, err)
+			require.NoError(t, c.Close())
+		}
+
+		// Once the queue has drained the listener accepts new connections again
+		c, err := net.DialTimeout("tcp", l.Addr().String(), 10*time.Second)
+		require.NoError(t, err)
+		accepted, err := l.Accept()
+		require.NoError(t, err)
+		require.Equal(t, c.LocalAddr().String(), accepted.RemoteAddr().String())
+		require.NoError(t, accepted.Close())
+		require.NoError(t, c.Close())
+
+		for _, c := range pending {
+			require.NoError(t, c.Close())
+		}
+		require.NoError(t, server.Close())
+		require.NoError(t, client.Close())
+		require.NoError(t, l.Close())
+	}
+}
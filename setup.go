@@ -68,8 +68,9 @@ func (r *Ring) QueueInit(entries uint32, flags uint32) error {
 
 // MMap is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/setup.c#L18
 func MMap(fd int, params *Params, sq *SubmissionQueue, cq *CompletionQueue) error {
+	sqeSize, cqeSize := entrySizes(params.Flags)
 	sq.RingSize = uint(uintptr(params.SQOffsets.Array) + uintptr(params.SQEntries)*uint32Size)
-	cq.RingSize = uint(uintptr(params.CQOffsets.CQEs) + uintptr(params.CQEntries)*cqEventSize)
+	cq.RingSize = uint(uintptr(params.CQOffsets.CQEs) + uintptr(params.CQEntries)*cqeSize)
 
 	if params.Features&uint32(FeatureSingleMMap) != 0 {
 		if cq.RingSize > sq.RingSize {
@@ -78,18 +79,18 @@ func MMap(fd int, params *Params, sq *SubmissionQueue, cq *CompletionQueue) erro
 		cq.RingSize = sq.RingSize
 	}
 
-	ringPtr, err := linked.MMap(0, uintptr(sq.RingSize), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE, fd, int64(SQRingOffset))
+	ringPtr, err := mmap(uintptr(sq.RingSize), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE, fd, int64(SQRingOffset))
 	if err != nil {
 		return fmt.Errorf("error while MMAPing SQ Ring: %w", err)
 	}
-	sq.RingPointer = unsafe.Pointer(ringPtr)
+	sq.RingPointer = ringPtr
 
-	ringPtr, err = linked.MMap(0, uintptr(cq.RingSize), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE, fd, int64(CQRingOffset))
+	ringPtr, err = mmap(uintptr(cq.RingSize), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE, fd, int64(CQRingOffset))
 	if err != nil {
 		MUnmap(sq, cq)
 		return fmt.Errorf("error while MMAPing CQ Ring: %w", err)
 	}
-	cq.RingPointer = unsafe.Pointer(ringPtr)
+	cq.RingPointer = ringPtr
 
 	sq.KHead = (*uint32)(unsafe.Pointer(uintptr(sq.RingPointer) + uintptr(params.SQOffsets.Head)))
 	sq.KTail = (*uint32)(unsafe.Pointer(uintptr(sq.RingPointer) + uintptr(params.SQOffsets.Tail)))
@@ -99,13 +100,13 @@ func MMap(fd int, params *Params, sq *SubmissionQueue, cq *CompletionQueue) erro
 	sq.KDropped = (*uint32)(unsafe.Pointer(uintptr(sq.RingPointer) + uintptr(params.SQOffsets.Dropped)))
 	sq.Array = (*uint32)(unsafe.Pointer(uintptr(sq.RingPointer) + uintptr(params.SQOffsets.Array)))
 
-	ringPtr, err = linked.MMap(0, sqEntrySize*uintptr(params.SQEntries), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE, fd, int64(SQEntriesOffset))
+	ringPtr, err = mmap(sqeSize*uintptr(params.SQEntries), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE, fd, int64(SQEntriesOffset))
 	if err != nil {
 		MUnmap(sq, cq)
 		return fmt.Errorf("error while MMAPing SQ Ring's SQ Entry: %w", err)
 	}
 
-	sq.SQEs = (*SQEntry)(unsafe.Pointer(ringPtr))
+	sq.SQEs = (*SQEntry)(ringPtr)
 
 	sq.RingMask = *sq._KRingMask
 	sq.RingEntries = *sq._KRingEntries
@@ -126,6 +127,29 @@ func MMap(fd int, params *Params, sq *SubmissionQueue, cq *CompletionQueue) erro
 	return nil
 }
 
+// mmap maps length bytes with linked.MMap at an address chosen by the kernel, the mapping
+// lives outside the Go heap, so its address can be held as an unsafe.Pointer
+func mmap(length uintptr, prot int, flags int, fd int, offset int64) (unsafe.Pointer, error) {
+	addr, err := linked.MMap(0, length, prot, flags, fd, offset)
+	if err != nil {
+		return nil, err
+	}
+	return *(*unsafe.Pointer)(unsafe.Pointer(&addr)), nil
+}
+
+// entrySizes returns the size of the SQEs and CQEs of a ring set up with flags, which
+// are twice the size of SQEntry and CQEvent with SetupSQE128 and SetupCQE32
+func entrySizes(flags uint32) (uintptr, uintptr) {
+	sqeSize, cqeSize := sqEntrySize, cqEventSize
+	if flags&uint32(SetupSQE128) != 0 {
+		sqeSize *= 2
+	}
+	if flags&uint32(SetupCQE32) != 0 {
+		cqeSize *= 2
+	}
+	return sqeSize, cqeSize
+}
+
 // MUnmap is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/setup.c#L11
 func MUnmap(sq *SubmissionQueue, cq *CompletionQueue) {
 	if sq.RingSize > 0 {
@@ -0,0 +1,267 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// SubmitterQueueSize is the default number of requests that can be queued on a
+	// Submitter before Submit starts blocking
+	SubmitterQueueSize = 1024
+
+	// submitterRetryInterval bounds how long the submitter waits for completions to be
+	// reaped before it retries a submission that was rejected with EBUSY
+	submitterRetryInterval = time.Millisecond
+)
+
+var (
+	ErrSubmitterClosed = errors.New("submitter is closed")
+	ErrSingleIssuer    = errors.New("ring was set up with SetupSingleIssuer and only accepts requests from the thread that created it")
+)
+
+// SubmitQueue hands out the free SQEs of a Ring to the prepare functions of a Submitter
+type SubmitQueue struct {
+	ring *Ring
+}
+
+// GetSQEntry reserves the next free SQE, it returns ErrSQFull if there is none
+func (q *SubmitQueue) GetSQEntry() (*SQEntry, error) {
+	sqe := q.ring.GetSQEntry()
+	if sqe == nil {
+		return nil, ErrSQFull
+	}
+	return sqe, nil
+}
+
+type submitRequest struct {
+	prepare func(q *SubmitQueue) error
+	err     chan error
+}
+
+// Submitter is a goroutine-safe front-end for the submission side of a Ring
+//
+// Any number of goroutines can call Submit, while a single goroutine owns the submission
+// queue, prepares the queued requests into SQEs, and submits everything that was queued
+// together with a single Submit. When the submission queue is full, the requests that
+// were already prepared are submitted to make room, and when the kernel rejects a
+// submission because too many completions are pending, the submitter waits for
+// completions to be reaped before trying again. Callers are held up in the meantime,
+// and once the queue of pending requests is full Submit blocks as well.
+type Submitter struct {
+	ring     *Ring
+	requests chan *submitRequest
+	reaped   chan struct{}
+	pending  []*submitRequest
+
+	// err is set when the submitter's goroutine cannot submit to the ring at all
+	err error
+
+	mu     sync.RWMutex
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewSubmitter starts a goroutine that owns the submission side of ring, up to queueSize
+// requests can be waiting to be submitted before Submit blocks
+//
+// Rings set up with SetupSingleIssuer only accept requests from the thread that created
+// them, which the submitter's goroutine is not, so Submit fails with ErrSingleIssuer for them.
+func NewSubmitter(ring *Ring, queueSize int) *Submitter {
+	s := &Submitter{
+		ring:     ring,
+		requests: make(chan *submitRequest, queueSize),
+		reaped:   make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if ring.Flags&uint32(SetupSingleIssuer) != 0 {
+		s.err = ErrSingleIssuer
+	}
+
+	go s.loop()
+
+	return s
+}
+
+// Submit queues prepare and blocks until the SQEs it reserved have been submitted
+//
+// prepare is called on the submitter's goroutine, and may be called again if the
+// submission queue fills up while it is reserving SQEs, so it must prepare the
+// same requests every time it is called. If prepare returns an error the SQEs
+// it reserved are released and the error is returned.
+func (s *Submitter) Submit(prepare func(q *SubmitQueue) error) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	req := &submitRequest{
+		prepare: prepare,
+		err:     make(chan error, 1),
+	}
+
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return ErrSubmitterClosed
+	}
+	s.requests <- req
+	s.mu.RUnlock()
+
+	return <-req.err
+}
+
+// Reaped tells the submitter that completions have been consumed, which lets it retry
+// submissions that were rejected because the completion queue was full
+func (s *Submitter) Reaped() {
+	select {
+	case s.reaped <- struct{}{}:
+	default:
+	}
+}
+
+// Close submits the requests that are still queued and stops the submitter's goroutine,
+// the ring itself is left open
+func (s *Submitter) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrSubmitterClosed
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+
+	return nil
+}
+
+// loop processes queued requests until the submitter is closed and the queue is empty
+func (s *Submitter) loop() {
+	defer close(s.done)
+
+	for {
+		select {
+		case req := <-s.requests:
+			s.process(req)
+		case <-s.stop:
+			for {
+				select {
+				case req := <-s.requests:
+					s.process(req)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// process prepares req and every request that is queued behind it, and submits them
+func (s *Submitter) process(req *submitRequest) {
+	for req != nil {
+		tail := s.ring.SQ.SQETail
+		err := req.prepare(&SubmitQueue{ring: s.ring})
+		if err != nil {
+			s.ring.SQ.SQETail = tail
+		}
+
+		if errors.Is(err, ErrSQFull) && s.ring.SQReady() > 0 {
+			s.flush()
+			s.waitSQ()
+			continue
+		}
+
+		if err != nil {
+			req.err <- err
+		} else {
+			s.pending = append(s.pending, req)
+		}
+
+		select {
+		case req = <-s.requests:
+		default:
+			req = nil
+		}
+	}
+
+	s.flush()
+}
+
+// flush submits the SQEs of every pending request until the kernel has consumed all of
+// them, and reports the result to them
+func (s *Submitter) flush() {
+	if len(s.pending) == 0 {
+		return
+	}
+
+	sqPoll := s.ring.Flags&uint32(SetupSQPoll) != 0
+	var err error
+	for {
+		var n uint
+		n, err = s.ring.Submit()
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EAGAIN) {
+			s.waitReaped()
+			continue
+		}
+		if err != nil || sqPoll || s.ring.SQReady() == 0 {
+			break
+		}
+
+		// The kernel stops consuming SQEs after one that fails to be prepared, the ones
+		// behind it belong to other requests and are submitted again
+		if n == 0 {
+			s.waitReaped()
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("error while submitting SQEs to ring with fd %d: %w", s.ring.FD, err)
+	}
+
+	for _, req := range s.pending {
+		req.err <- err
+	}
+	clear(s.pending)
+	s.pending = s.pending[:0]
+}
+
+// waitSQ waits for an SQPOLL thread to make room in the SQ, without SQPOLL
+// the kernel consumes the SQEs while they are being submitted
+func (s *Submitter) waitSQ() {
+	_ = s.ring.SQRingWait()
+}
+
+// waitReaped waits until completions have been reaped, or for at most submitterRetryInterval
+func (s *Submitter) waitReaped() {
+	timer := time.NewTimer(submitterRetryInterval)
+	defer timer.Stop()
+
+	select {
+	case <-s.reaped:
+	case <-timer.C:
+	}
+}
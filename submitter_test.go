@@ -0,0 +1,144 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"math"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSubmitter(t *testing.T) {
+	ring, err := NewRing()
+	require.NoError(t, err)
+	require.NoError(t, ring.QueueInit(8, 0))
+	t.Cleanup(func() {
+		_ = ring.Close()
+	})
+
+	s := NewSubmitter(ring, 16)
+
+	const goroutines = 32
+	const requests = 64
+	const total = goroutines * requests * 2
+
+	var reaped atomic.Uint64
+	reaperDone := make(chan struct{})
+	go func() {
+		defer close(reaperDone)
+		for reaped.Load() < total {
+			cqe, err := ring.WaitCQEvent()
+			if err != nil {
+				continue
+			}
+			require.Zero(t, cqe.Res)
+			ring.CQESeen(cqe)
+			reaped.Add(1)
+			s.Reaped()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				err := s.Submit(func(q *SubmitQueue) error {
+					for k := 0; k < 2; k++ {
+						sqe, err := q.GetSQEntry()
+						if err != nil {
+							return err
+						}
+						sqe.PrepareNop()
+					}
+					return nil
+				})
+				require.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	<-reaperDone
+
+	require.Equal(t, uint64(total), reaped.Load())
+
+	errPrepare := errors.New("prepare failed")
+	err = s.Submit(func(q *SubmitQueue) error {
+		_, err := q.GetSQEntry()
+		require.NoError(t, err)
+		return errPrepare
+	})
+	require.ErrorIs(t, err, errPrepare)
+	require.Zero(t, ring.SQReady())
+
+	err = s.Submit(func(q *SubmitQueue) error {
+		for {
+			_, err := q.GetSQEntry()
+			if err != nil {
+				return err
+			}
+		}
+	})
+	require.ErrorIs(t, err, ErrSQFull)
+	require.Zero(t, ring.SQReady())
+
+	require.NoError(t, s.Close())
+	require.ErrorIs(t, s.Close(), ErrSubmitterClosed)
+	require.ErrorIs(t, s.Submit(func(q *SubmitQueue) error { return nil }), ErrSubmitterClosed)
+}
+
+func TestSubmitterShortSubmit(t *testing.T) {
+	d := newTestDispatcher(t, 8)
+	t.Cleanup(func() {
+		_ = d.Close()
+	})
+
+	invalid, nop := NewFuture(), NewFuture()
+	err := d.Submit(func(s *Submission) error {
+		sqe, err := s.Entry(invalid)
+		if err != nil {
+			return err
+		}
+		sqe.PrepareNop()
+		sqe.OpCode = math.MaxUint8
+
+		sqe, err = s.Entry(nop)
+		if err != nil {
+			return err
+		}
+		sqe.PrepareNop()
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The kernel stops at the invalid SQE, the nop behind it must be submitted as well
+	for _, f := range []*Future{invalid, nop} {
+		select {
+		case <-f.Done():
+		case <-time.After(time.Second):
+			require.FailNow(t, "timed out waiting for completion")
+		}
+	}
+	require.Equal(t, -int32(syscall.EINVAL), invalid.Result().Res)
+	require.Zero(t, nop.Result().Res)
+}
@@ -62,12 +62,12 @@ type Params struct {
 }
 
 // CQEvent is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L357
+//
+// On rings set up with SetupCQE32 every CQE is followed by 16 more bytes, which are read with BigCQE.
 type CQEvent struct {
 	UserData uint64
 	Res      int32
 	Flags    uint32
-
-	BigCQE *uint64
 }
 
 // UnionAddress3 is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L88
@@ -93,6 +93,69 @@ type SQEntry struct {
 	UnionAddress3          UnionAddress3
 }
 
+// SQEntryFlag is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h
+type SQEntryFlag uint8
+
+const (
+	SQEntryFlagFixedFile SQEntryFlag = 1 << iota
+	SQEntryFlagIODrain
+	SQEntryFlagIOLink
+	SQEntryFlagIOHardLink
+	SQEntryFlagAsync
+	SQEntryFlagBufferSelect
+	SQEntryFlagCQESkipSuccess
+)
+
+// AcceptFlag is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h
+type AcceptFlag uint16
+
+const (
+	AcceptFlagMultishot AcceptFlag = 1 << iota
+)
+
+// AsyncCancelFlag is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h
+type AsyncCancelFlag uint32
+
+const (
+	AsyncCancelFlagAll AsyncCancelFlag = 1 << iota
+	AsyncCancelFlagFD
+	AsyncCancelFlagAny
+	AsyncCancelFlagFDFixed
+)
+
+// TimeoutFlag is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h
+type TimeoutFlag uint32
+
+const (
+	TimeoutFlagAbs TimeoutFlag = 1 << iota
+	TimeoutFlagUpdate
+	TimeoutFlagBootTime
+	TimeoutFlagRealTime
+	TimeoutFlagLinkTimeoutUpdate
+	TimeoutFlagETimeSuccess
+)
+
+// KernelTimespec is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/compat.h
+type KernelTimespec struct {
+	Sec  int64
+	Nsec int64
+}
+
+// CQEventFlag is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h
+type CQEventFlag uint32
+
+const (
+	CQEventFlagBuffer CQEventFlag = 1 << iota
+	CQEventFlagMore
+	CQEventFlagSockNonEmpty
+	CQEventFlagNotif
+)
+
+// CQEventBufferShift is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L399
+//
+// The ID of the buffer a buffer select request picked is stored in the upper bits of the CQE flags.
+const CQEventBufferShift = 16
+
 // SubmissionQueue is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing.h#L84
 type SubmissionQueue struct {
 	KHead         *uint32
@@ -200,9 +263,30 @@ const (
 	OpCodeSendZC
 	OpCodeSendMsgZC
 
+	// The following opcodes are defined here: https://github.com/axboe/liburing/blob/liburing-2.6/src/include/liburing/io_uring.h#L253
+	OpCodeReadMultishot
+	OpCodeWaitID
+	OpCodeFutexWait
+	OpCodeFutexWake
+	OpCodeFutexWaitV
+	OpCodeFixedFDInstall
+
 	OpCodeLast
 )
 
+// FileIndexAlloc is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L83
+//
+// Passed as the file index of a direct accept, openat or socket request, it lets the kernel
+// pick a free slot in the range reserved with RegisterFileAllocRange.
+const FileIndexAlloc = math.MaxUint32
+
+// FixedFDInstallFlag is defined here: https://github.com/axboe/liburing/blob/liburing-2.6/src/include/liburing/io_uring.h#L392
+type FixedFDInstallFlag uint32
+
+const (
+	FixedFDInstallFlagNoCloexec FixedFDInstallFlag = 1 << iota
+)
+
 // Setup is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L140
 type Setup uint32
 
@@ -316,6 +400,99 @@ const (
 	RegisterOpCodeRegisterUseRegisteredRing = 1 << 31
 )
 
+// RsrcRegisterFlag is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L530
+type RsrcRegisterFlag uint32
+
+const (
+	RsrcRegisterFlagSparse RsrcRegisterFlag = 1 << iota
+)
+
+// FilesUpdate is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L523
+type FilesUpdate struct {
+	Offset uint32
+	ResV   uint32
+	FDs    uint64
+}
+
+// RsrcRegister is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L532
+type RsrcRegister struct {
+	NR    uint32
+	Flags uint32
+	ResV2 uint64
+	Data  uint64
+	Tags  uint64
+}
+
+// RsrcUpdate2 is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L546
+type RsrcUpdate2 struct {
+	Offset uint32
+	ResV   uint32
+	Data   uint64
+	Tags   uint64
+	NR     uint32
+	ResV2  uint32
+}
+
+// FileIndexRange is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L626
+type FileIndexRange struct {
+	Offset uint32
+	Length uint32
+	ResV   uint64
+}
+
+// BufRingEntry is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L634
+//
+// The ResV field of the first entry of a buffer ring holds the ring's tail.
+type BufRingEntry struct {
+	Address  uint64
+	Length   uint32
+	BufferID uint16
+	ResV     uint16
+}
+
+// PBufRingFlag is defined here: https://github.com/axboe/liburing/blob/liburing-2.5/src/include/liburing/io_uring.h#L664
+type PBufRingFlag uint16
+
+const (
+	PBufRingFlagMMap PBufRingFlag = 1 << iota
+)
+
+// BufReg is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L655
+type BufReg struct {
+	RingAddress uint64
+	RingEntries uint32
+	BufferGroup uint16
+	Flags       uint16
+	ResV        [3]uint64
+}
+
+// ProbeOpFlag is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L577
+type ProbeOpFlag uint16
+
+const (
+	ProbeOpFlagSupported ProbeOpFlag = 1 << iota
+)
+
+// ProbeOp is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L578
+type ProbeOp struct {
+	Op    uint8
+	ResV  uint8
+	Flags uint16
+	ResV2 uint32
+}
+
+// Probe is defined here: https://github.com/axboe/liburing/blob/liburing-2.4/src/include/liburing/io_uring.h#L585
+//
+// Unlike the C struct, which ends in a flexible array, Ops has room for every opcode
+// the kernel could report.
+type Probe struct {
+	LastOp uint8
+	OpsLen uint8
+	ResV   uint16
+	ResV2  [3]uint32
+	Ops    [probeOps]ProbeOp
+}
+
 const (
 	// _NSIG is defined here: https://github.com/torvalds/linux/blob/v6.5/include/uapi/asm-generic/signal.h#L7
 	_NSIG = 64
@@ -0,0 +1,63 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"unsafe"
+)
+
+// TestLayout checks that the structs shared with the kernel match the layout in io_uring.h
+func TestLayout(t *testing.T) {
+	var sqe SQEntry
+	require.Equal(t, uintptr(64), unsafe.Sizeof(sqe))
+	require.Equal(t, uintptr(0), unsafe.Offsetof(sqe.OpCode))
+	require.Equal(t, uintptr(1), unsafe.Offsetof(sqe.Flags))
+	require.Equal(t, uintptr(2), unsafe.Offsetof(sqe.IOPriority))
+	require.Equal(t, uintptr(4), unsafe.Offsetof(sqe.FD))
+	require.Equal(t, uintptr(8), unsafe.Offsetof(sqe.UnionOffset))
+	require.Equal(t, uintptr(16), unsafe.Offsetof(sqe.UnionAddress))
+	require.Equal(t, uintptr(24), unsafe.Offsetof(sqe.Length))
+	require.Equal(t, uintptr(28), unsafe.Offsetof(sqe.UnionRWFlags))
+	require.Equal(t, uintptr(32), unsafe.Offsetof(sqe.UserData))
+	require.Equal(t, uintptr(40), unsafe.Offsetof(sqe.UnionBufferIndexPacked))
+	require.Equal(t, uintptr(42), unsafe.Offsetof(sqe.Personality))
+	require.Equal(t, uintptr(44), unsafe.Offsetof(sqe.UnionSplicedFDIn))
+	require.Equal(t, uintptr(48), unsafe.Offsetof(sqe.UnionAddress3))
+	require.Equal(t, uintptr(48), uintptr(unsafe.Pointer(sqe.Command()))-uintptr(unsafe.Pointer(&sqe)))
+	require.Equal(t, uintptr(128), unsafe.Offsetof(sqe.UnionAddress3)+SQECommandSize)
+
+	var cqe CQEvent
+	require.Equal(t, uintptr(16), unsafe.Sizeof(cqe))
+	require.Equal(t, uintptr(0), unsafe.Offsetof(cqe.UserData))
+	require.Equal(t, uintptr(8), unsafe.Offsetof(cqe.Res))
+	require.Equal(t, uintptr(12), unsafe.Offsetof(cqe.Flags))
+	require.Equal(t, uintptr(16), uintptr(unsafe.Pointer(cqe.BigCQE()))-uintptr(unsafe.Pointer(&cqe)))
+
+	var params Params
+	require.Equal(t, uintptr(120), unsafe.Sizeof(params))
+	require.Equal(t, uintptr(40), unsafe.Offsetof(params.SQOffsets))
+	require.Equal(t, uintptr(80), unsafe.Offsetof(params.CQOffsets))
+	require.Equal(t, uintptr(40), unsafe.Sizeof(SQRingOffsets{}))
+	require.Equal(t, uintptr(40), unsafe.Sizeof(CQRingOffsets{}))
+
+	require.Equal(t, uintptr(16), unsafe.Sizeof(KernelTimespec{}))
+	require.Equal(t, uintptr(24), unsafe.Sizeof(GetEventsArg{}))
+	require.Equal(t, uintptr(8), unsafe.Sizeof(ProbeOp{}))
+	require.Equal(t, uintptr(16), unsafe.Offsetof(Probe{}.Ops))
+}
@@ -0,0 +1,271 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+)
+
+var (
+	_ net.Listener = (*UnixListener)(nil)
+	_ net.Conn     = (*UnixConn)(nil)
+)
+
+var (
+	ErrControlTruncated = errors.New("control message was truncated")
+)
+
+// UnixListener is a Listener for Unix domain sockets, it accepts *UnixConn connections
+type UnixListener struct {
+	*Listener
+	path       string
+	unlinkOnce sync.Once
+}
+
+// ListenUnix listens for connections on the Unix socket at path, network must be
+// "unix" for stream sockets or "unixpacket" for seqpacket sockets, and paths
+// starting with '@' are bound in the abstract namespace
+func ListenUnix(network string, path string) (*UnixListener, error) {
+	sotype, err := unixSocketType(network)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_UNIX, sotype|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening listening socket: %w", err)
+	}
+
+	err = syscall.Bind(fd, &syscall.SockaddrUnix{Name: path})
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, fmt.Errorf("error binding listening socket with fd %d to listen address %s: %w", fd, path, err)
+	}
+
+	l, err := newListener(fd, network, false, func(fd int, addr net.Addr) (net.Conn, error) {
+		conn, err := newConnFromFD(fd, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &UnixConn{Conn: conn}, nil
+	})
+	if err != nil {
+		_ = syscall.Close(fd)
+		if len(path) > 0 && path[0] != '@' {
+			_ = syscall.Unlink(path)
+		}
+		return nil, err
+	}
+
+	return &UnixListener{
+		Listener: l,
+		path:     path,
+	}, nil
+}
+
+// AcceptUnix waits for and returns the next Unix connection accepted by the ring
+func (l *UnixListener) AcceptUnix() (*UnixConn, error) {
+	conn, err := l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*UnixConn), nil
+}
+
+// Close stops the listener and removes the socket file it created
+func (l *UnixListener) Close() error {
+	err := l.Listener.Close()
+	if len(l.path) > 0 && l.path[0] != '@' {
+		l.unlinkOnce.Do(func() {
+			_ = syscall.Unlink(l.path)
+		})
+	}
+	return err
+}
+
+// UnixConn is a Conn for Unix domain sockets, which can additionally exchange
+// ancillary data such as file descriptors through sendmsg and recvmsg requests
+type UnixConn struct {
+	*Conn
+}
+
+// DialUnix connects to the Unix socket at path, submitting the connect request through
+// a new Ring, network must be "unix" or "unixpacket"
+func DialUnix(network string, path string) (*UnixConn, error) {
+	sotype, err := unixSocketType(network)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_UNIX, sotype|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error while opening dialing socket: %w", err)
+	}
+
+	dispatcher, err := newConnDispatcher(fd)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+
+	unixAddr := &net.UnixAddr{Name: path, Net: network}
+	remoteAddress := NewClientAddress()
+	err = remoteAddress.SetUnixAddr(unixAddr)
+	if err == nil {
+		err = connect(context.Background(), dispatcher, fd, remoteAddress)
+	}
+	if err != nil {
+		_ = dispatcher.Close()
+		_ = syscall.Close(fd)
+		return nil, &net.OpError{Op: "dial", Net: network, Addr: unixAddr, Err: err}
+	}
+
+	conn, err := newConn(fd, network, dispatcher, unixAddr)
+	if err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+
+	return &UnixConn{Conn: conn}, nil
+}
+
+// PeerCredentials returns the credentials of the peer process as reported by SO_PEERCRED,
+// for accepted connections these are the credentials the peer had when it called connect
+func (c *UnixConn) PeerCredentials() (*unix.Ucred, error) {
+	ucred, err := unix.GetsockoptUcred(c.fd, unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return nil, c.opError("getsockopt", fmt.Errorf("error while getting SO_PEERCRED for socket with fd %d: %w", c.fd, err))
+	}
+	return ucred, nil
+}
+
+// ReadMsgUnix submits a recvmsg request that reads data into b and ancillary data into oob,
+// received file descriptors are marked close-on-exec
+func (c *UnixConn) ReadMsgUnix(b []byte, oob []byte) (n int, oobn int, flags int, addr *net.UnixAddr, err error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	msg := newMessage(b, oob, true)
+	address := msg.withName()
+
+	c.readMsgs = []*message{msg}
+	res, err := c.do(context.Background(), EventRead, func(sqe *SQEntry) {
+		sqe.PrepareRecvMsg(c.fd, msg.pointer(), syscall.MSG_CMSG_CLOEXEC)
+	})
+	c.readMsgs = nil
+	if err != nil {
+		return 0, 0, 0, nil, c.opError("read", err)
+	}
+	if res < 0 {
+		return 0, 0, 0, nil, c.opError("read", c.resError("recvmsg", res))
+	}
+	if res == 0 && c.isClosed() {
+		return 0, 0, 0, nil, c.opError("read", net.ErrClosed)
+	}
+
+	n, oobn, flags = msg.n(res), int(msg.hdr.Controllen), int(msg.hdr.Flags)
+	if msg.hdr.Namelen > 0 {
+		addr = address.UnixAddr(c.network)
+	}
+	if res == 0 && len(b) > 0 && isConnectionOriented(c.network) {
+		err = io.EOF
+	}
+
+	return
+}
+
+// WriteMsgUnix submits a sendmsg request that writes b along with the ancillary data in oob,
+// addr must be nil for connected sockets
+func (c *UnixConn) WriteMsgUnix(b []byte, oob []byte, addr *net.UnixAddr) (n int, oobn int, err error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	msg := newMessage(b, oob, true)
+	if addr != nil {
+		err = msg.withName().SetUnixAddr(addr)
+		if err != nil {
+			return 0, 0, c.opError("write", err)
+		}
+	}
+
+	c.writeMsgs = []*message{msg}
+	res, err := c.do(context.Background(), EventWrite, func(sqe *SQEntry) {
+		sqe.PrepareSendMsg(c.fd, msg.pointer(), syscall.MSG_NOSIGNAL)
+	})
+	c.writeMsgs = nil
+	if err != nil {
+		return 0, 0, c.opError("write", err)
+	}
+	if res < 0 {
+		return 0, 0, c.opError("write", c.resError("sendmsg", res))
+	}
+
+	return msg.n(res), len(oob), nil
+}
+
+// WriteFDs writes b and passes fds to the peer as SCM_RIGHTS ancillary data
+func (c *UnixConn) WriteFDs(b []byte, fds ...int) (int, error) {
+	n, _, err := c.WriteMsgUnix(b, unix.UnixRights(fds...), nil)
+	return n, err
+}
+
+// ReadFDs reads into b and returns up to maxFDs file descriptors that were passed
+// by the peer as SCM_RIGHTS ancillary data
+func (c *UnixConn) ReadFDs(b []byte, maxFDs int) (int, []int, error) {
+	oob := make([]byte, unix.CmsgSpace(maxFDs*4))
+	n, oobn, flags, _, err := c.ReadMsgUnix(b, oob)
+	if err != nil && oobn == 0 {
+		return n, nil, err
+	}
+
+	messages, parseErr := unix.ParseSocketControlMessage(oob[:oobn])
+	if parseErr != nil {
+		return n, nil, c.opError("read", fmt.Errorf("error while parsing control message: %w", parseErr))
+	}
+
+	var fds []int
+	for i := range messages {
+		rights, parseErr := unix.ParseUnixRights(&messages[i])
+		if parseErr != nil {
+			continue
+		}
+		fds = append(fds, rights...)
+	}
+
+	if flags&syscall.MSG_CTRUNC != 0 {
+		return n, fds, c.opError("read", ErrControlTruncated)
+	}
+
+	return n, fds, err
+}
+
+func unixSocketType(network string) (int, error) {
+	switch network {
+	case "unix":
+		return syscall.SOCK_STREAM, nil
+	case "unixpacket":
+		return syscall.SOCK_SEQPACKET, nil
+	}
+	return 0, net.UnknownNetworkError(network)
+}
@@ -0,0 +1,118 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package iouring
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestUnixConn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sock")
+	l, err := ListenUnix("unix", path)
+	require.NoError(t, err)
+
+	client, err := DialUnix("unix", path)
+	require.NoError(t, err)
+
+	server, err := l.AcceptUnix()
+	require.NoError(t, err)
+	require.Equal(t, path, l.Addr().String())
+	require.Equal(t, path, server.LocalAddr().String())
+
+	ucred, err := server.PeerCredentials()
+	require.NoError(t, err)
+	require.Equal(t, int32(os.Getpid()), ucred.Pid)
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = r.Close()
+	})
+
+	n, err := client.WriteFDs([]byte("fd"), int(w.Fd()))
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.NoError(t, w.Close())
+
+	buf := make([]byte, 2)
+	n, fds, err := server.ReadFDs(buf, 1)
+	require.NoError(t, err)
+	require.Equal(t, "fd", string(buf[:n]))
+	require.Len(t, fds, 1)
+
+	received := os.NewFile(uintptr(fds[0]), "received")
+	_, err = received.Write([]byte("through the pipe"))
+	require.NoError(t, err)
+	require.NoError(t, received.Close())
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "through the pipe", string(data))
+
+	require.NoError(t, client.Close())
+	_, err = server.Read(buf)
+	require.ErrorIs(t, err, io.EOF)
+	require.NoError(t, server.Close())
+
+	require.NoError(t, l.Close())
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestUnixConnSeqPacket(t *testing.T) {
+	path := "@iouring-test-" + t.Name()
+	l, err := ListenUnix("unixpacket", path)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, l.Close())
+	})
+
+	client, err := DialUnix("unixpacket", path)
+	require.NoError(t, err)
+
+	server, err := l.AcceptUnix()
+	require.NoError(t, err)
+
+	_, err = client.Write([]byte("first"))
+	require.NoError(t, err)
+	_, err = client.Write([]byte("second"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 16)
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "first", string(buf[:n]))
+
+	n, _, flags, _, err := server.ReadMsgUnix(buf[:3], nil)
+	require.NoError(t, err)
+	require.Equal(t, "sec", string(buf[:n]))
+	require.NotZero(t, flags&syscall.MSG_TRUNC)
+
+	// Reads return EOF once the peer has closed the connection, instead of empty packets
+	require.NoError(t, client.Close())
+	_, err = server.Read(buf)
+	require.ErrorIs(t, err, io.EOF)
+	_, _, _, _, err = server.ReadMsgUnix(buf, nil)
+	require.ErrorIs(t, err, io.EOF)
+
+	require.NoError(t, server.Close())
+}